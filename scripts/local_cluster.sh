@@ -0,0 +1,38 @@
+#!/bin/sh
+#
+# Start a cluster of N fs513d nodes on 127.0.0.1. Node i uses base port BASE + 10*i, the first node is
+# the introducer and every other node joins it, the other nodes are seeds to join through while the
+# introducer is down. Logs, replicas and control sockets go under WORKDIR.
+# Talk to a node with FS513_SOCKET=<socket> fs513 <command>, or groupmain -socket <socket>.
+#
+# Usage: scripts/local_cluster.sh [N] [BASE] [WORKDIR]
+
+N=${1:-5}
+BASE=${2:-50000}
+WORKDIR=${3:-/tmp/fs513}
+BIN=${BIN:-./fs513d}
+CLI=${CLI:-./fs513}
+
+mkdir -p "$WORKDIR"
+seeds=""
+i=1
+while [ "$i" -lt "$N" ]; do
+	seeds="$seeds${seeds:+,}127.0.0.1:$((BASE + 10 * i))"
+	i=$((i + 1))
+done
+
+i=0
+while [ "$i" -lt "$N" ]; do
+	port=$((BASE + 10 * i))
+	socket="$WORKDIR/fs513-$port.sock"
+	"$BIN" -introducer "127.0.0.1:$BASE" ${seeds:+-seeds "$seeds"} -advertise-addr 127.0.0.1 -listen-addr 127.0.0.1 \
+		-port "$port" -storage-path "$WORKDIR/files/{port}/" -log-path "$WORKDIR/logs/{port}.log" \
+		-control-socket "$socket" > "$WORKDIR/node-$port.out" 2>&1 &
+	pid=$!
+	sleep 1
+	if [ "$i" -gt 0 ]; then
+		"$CLI" -socket "$socket" join > /dev/null
+	fi
+	echo "node 127.0.0.1:$port pid $pid socket $socket"
+	i=$((i + 1))
+done
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"fs513"
+	"fs513client"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * fs513 is the scriptable client of a running node. It talks to the node on this host over its control
+ * socket, or to the admin API at -addr. Commands reading or writing local paths are only served on the
+ * control socket, so put, get and get-versions refuse -addr.
+ *
+ * Exit codes: 0 success, 1 the operation failed, 2 usage error, 3 no node is reachable, 4 no such file,
+ * 5 a local path cannot be read or written.
+ */
+const (
+	EXIT_OK          = 0
+	EXIT_FAILED      = 1
+	EXIT_USAGE       = 2
+	EXIT_UNAVAILABLE = 3
+	EXIT_NOT_FOUND   = 4
+	EXIT_IO          = 5
+
+	ADMIN_ENV = "FS513_ADMIN" // Environment variable holding the admin address, host:port, used instead of the socket
+)
+
+type command struct {
+	usage string
+	args  func(n int) bool // Accepted number of positional arguments
+	run   func(c *fs513client.Client, args []string) (interface{}, error)
+	print func(w io.Writer, v interface{})
+}
+
+// Commands with a local path, which the admin API only serves on the control socket
+var controlOnly = map[string]bool{"put": true, "get": true, "get-versions": true}
+
+var commands = map[string]command{
+	"put":          {"put <local> <name> [n]         store a local file under name, as its next version if name exists, with n replicas", between(2, 3), runPut, printFile},
+	"get":          {"get <name> [dest]              copy a file to dest, ./<base of name> by default", between(1, 2), runGet, printFile},
+	"get-versions": {"get-versions <name> <k> [dest] write the last k versions to dest, newest first", between(2, 3), runGetVersions, printFile},
+	"setrep":       {"setrep <name> <n>              keep n replicas of a file", exactly(2), runSetrep, printFile},
+	"rm": {"rm <name>                      remove a file and its replicas", exactly(1), func(c *fs513client.Client, args []string) (interface{}, error) {
+		return c.Remove(args[0])
+	}, printFile},
+	"ls": {"ls [prefix]                    list the files, or those starting with prefix", between(0, 1), func(c *fs513client.Client, args []string) (interface{}, error) {
+		return c.Files(strings.Join(args, ""))
+	}, printFiles},
+	"locate": {"locate <name>                  hosts holding a replica of a file", exactly(1), func(c *fs513client.Client, args []string) (interface{}, error) {
+		return c.Stat(args[0])
+	}, printLocate},
+	"stat": {"stat <name>                    versions, replicas and local size of a file", exactly(1), func(c *fs513client.Client, args []string) (interface{}, error) {
+		return c.Stat(args[0])
+	}, printStat},
+	"members": {"members                        membership list", exactly(0), func(c *fs513client.Client, args []string) (interface{}, error) {
+		return c.Members()
+	}, printMembers},
+	"grep": {"grep [-icnvwxEFo] <pattern>    grep the logs of every member", between(1, -1), func(c *fs513client.Client, args []string) (interface{}, error) {
+		return c.Grep(args)
+	}, printGrep},
+	"self": {"self                           ID, state and leader of the node", exactly(0), func(c *fs513client.Client, args []string) (interface{}, error) {
+		return c.Self()
+	}, printSelf},
+	"join": {"join                           join the group", exactly(0), func(c *fs513client.Client, args []string) (interface{}, error) {
+		return c.Join()
+	}, printSelf},
+	"leave": {"leave                          hand off the replicas and leave the group", exactly(0), func(c *fs513client.Client, args []string) (interface{}, error) {
+		return c.Leave()
+	}, printSelf},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(argv []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("fs513", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	socket := fs.String("socket", fs513client.DefaultSocket(), "control socket of the local node, or set "+fs513client.SOCKET_ENV)
+	addr := fs.String("addr", os.Getenv(ADMIN_ENV), "admin address host:port of the node, used instead of the socket, or set "+ADMIN_ENV)
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	fs.Usage = func() { usage(stderr, fs) }
+
+	// Flags may come before or after the subcommand and its arguments, except for grep which passes
+	// everything after it on to grep
+	args := make([]string, 0)
+	for {
+		if err := fs.Parse(argv); err != nil {
+			return EXIT_USAGE
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		if len(args) == 0 && fs.Arg(0) == "grep" {
+			args = append(args, fs.Args()...)
+			break
+		}
+		args = append(args, fs.Arg(0))
+		argv = fs.Args()[1:]
+	}
+	if len(args) == 0 {
+		usage(stderr, fs)
+		return EXIT_USAGE
+	}
+	cmd, ok := commands[args[0]]
+	if !ok || !cmd.args(len(args)-1) {
+		usage(stderr, fs)
+		return EXIT_USAGE
+	}
+	if *addr != "" && controlOnly[args[0]] {
+		fmt.Fprintln(stderr, "fs513 "+args[0]+": local paths are only served on the control socket, use -socket instead of -addr or "+ADMIN_ENV)
+		return EXIT_USAGE
+	}
+
+	client := fs513client.NewUnix(*socket)
+	if *addr != "" {
+		client = fs513client.New(*addr)
+	}
+	v, err := cmd.run(client, args[1:])
+	if v != nil && (err == nil || args[0] == "grep") {
+		if *asJSON {
+			enc := json.NewEncoder(stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(v)
+		} else {
+			cmd.print(stdout, v)
+		}
+	}
+	if err != nil {
+		if *asJSON && args[0] != "grep" {
+			json.NewEncoder(stdout).Encode(map[string]string{"error": err.Error()})
+		}
+		fmt.Fprintln(stderr, "fs513 "+args[0]+":", err)
+		return exitCode(err)
+	}
+	return EXIT_OK
+}
+
+/*
+ * An argument of the command which is wrong before the node is asked at all
+ */
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+/*
+ * A local path which cannot be read or written, found before the node is asked
+ */
+type ioError struct {
+	error
+}
+
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case usageError:
+		return EXIT_USAGE
+	case ioError:
+		return EXIT_IO
+	case *fs513client.APIError:
+		if e.Status == 404 {
+			return EXIT_NOT_FOUND
+		}
+		return EXIT_FAILED
+	case *url.Error:
+		// No answer from the node at all
+		return EXIT_UNAVAILABLE
+	}
+	return EXIT_FAILED
+}
+
+/*
+ * Absolute path of a local file to read, checked to be a regular file
+ */
+func sourcePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", ioError{err}
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", ioError{err}
+	}
+	if !info.Mode().IsRegular() {
+		return "", ioError{errors.New(path + " is not a regular file")}
+	}
+	return abs, nil
+}
+
+/*
+ * Absolute path of a local file to write, checked to be in an existing directory
+ */
+func destPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", ioError{err}
+	}
+	if info, err := os.Stat(filepath.Dir(abs)); err != nil {
+		return "", ioError{err}
+	} else if !info.IsDir() {
+		return "", ioError{errors.New(filepath.Dir(path) + " is not a directory")}
+	}
+	return abs, nil
+}
+
+func usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "Usage: fs513 [-socket path | -addr host:port] [-json] <command> [args]")
+	fmt.Fprintln(w, "Flags may also follow the arguments, except for grep")
+	fmt.Fprintln(w, "put, get and get-versions use local paths and need the control socket")
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range []string{"put", "get", "get-versions", "setrep", "rm", "ls", "locate", "stat", "members", "grep", "self", "join", "leave"} {
+		fmt.Fprintln(w, "  "+commands[name].usage)
+	}
+	fmt.Fprintln(w, "Flags:")
+	fs.PrintDefaults()
+}
+
+func exactly(want int) func(int) bool {
+	return func(n int) bool { return n == want }
+}
+
+/*
+ * Between min and max arguments, max -1 for no limit
+ */
+func between(min int, max int) func(int) bool {
+	return func(n int) bool { return n >= min && (max == -1 || n <= max) }
+}
+
+/*
+ * The node resolves paths on its own host, which is this host, so relative paths are made absolute here
+ */
+func runPut(c *fs513client.Client, args []string) (interface{}, error) {
+	local, err := sourcePath(args[0])
+	if err != nil {
+		return nil, err
+	}
+	replication := 0
+	if len(args) == 3 {
+		if replication, err = strconv.Atoi(args[2]); err != nil || replication < 1 {
+			return nil, usageError("the number of replicas must be a positive integer")
+		}
+	}
+	return c.Put(local, args[1], replication)
+}
+
+func runSetrep(c *fs513client.Client, args []string) (interface{}, error) {
+	replication, err := strconv.Atoi(args[1])
+	if err != nil || replication < 1 {
+		return nil, usageError("the number of replicas must be a positive integer")
+	}
+	return c.SetReplication(args[0], replication)
+}
+
+func runGet(c *fs513client.Client, args []string) (interface{}, error) {
+	dest := filepath.Base(args[0])
+	if len(args) == 2 {
+		dest = args[1]
+	}
+	dest, err := destPath(dest)
+	if err != nil {
+		return nil, err
+	}
+	return c.Fetch(args[0], dest)
+}
+
+func runGetVersions(c *fs513client.Client, args []string) (interface{}, error) {
+	k, err := strconv.Atoi(args[1])
+	if err != nil || k < 1 {
+		return nil, usageError("the number of versions must be a positive integer")
+	}
+	dest := filepath.Base(args[0])
+	if len(args) == 3 {
+		dest = args[2]
+	}
+	dest, err = destPath(dest)
+	if err != nil {
+		return nil, err
+	}
+	return c.FetchVersions(args[0], k, dest)
+}
+
+func printFile(w io.Writer, v interface{}) {
+	f := v.(fs513.FileInfo)
+	fmt.Fprintln(w, f.Name+" "+strings.Join(f.Replicas, ","))
+}
+
+func printFiles(w io.Writer, v interface{}) {
+	for _, f := range v.([]fs513.FileInfo) {
+		printFile(w, f)
+	}
+}
+
+func printLocate(w io.Writer, v interface{}) {
+	for _, host := range v.(fs513.FileInfo).Replicas {
+		fmt.Fprintln(w, host)
+	}
+}
+
+func printStat(w io.Writer, v interface{}) {
+	f := v.(fs513.FileInfo)
+	fmt.Fprintln(w, "Name:     "+f.Name)
+	fmt.Fprintln(w, "Replicas: "+strings.Join(f.Replicas, ", ")+" ("+strconv.Itoa(f.Replication)+" wanted)")
+	fmt.Fprintln(w, "Version:  "+strconv.FormatUint(f.Version, 10)+", "+strconv.Itoa(len(f.Versions))+" kept")
+	fmt.Fprintln(w, "Checksum: sha256:"+f.Checksum)
+	if f.Local {
+		fmt.Fprintln(w, "Local:    yes, "+strconv.FormatInt(f.Size, 10)+" bytes")
+	} else {
+		fmt.Fprintln(w, "Local:    no")
+	}
+}
+
+func printMembers(w io.Writer, v interface{}) {
+	for _, m := range v.([]fs513.Member) {
+		keys := make([]string, 0)
+		for k := range m.Meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		line := m.Host + " " + m.State + " " + strconv.FormatUint(m.Incarnation, 10)
+		for _, k := range keys {
+			line += " " + k + "=" + m.Meta[k]
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+func printSelf(w io.Writer, v interface{}) {
+	self := v.(fs513.SelfInfo)
+	fmt.Fprintln(w, self.ID+" incarnation "+strconv.FormatUint(self.Incarnation, 10)+" state "+string(self.State))
+	fmt.Fprintln(w, "Leader: "+self.Leader)
+}
+
+func printGrep(w io.Writer, v interface{}) {
+	for _, result := range v.(fs513.GrepResult).Results {
+		fmt.Fprintln(w, result)
+		fmt.Fprintln(w, "END----------------------------------------------------------")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+ * A node on a control socket in dir answering every file as known except "missing", and refusing setrep
+ */
+func fakeNode(t *testing.T, dir string) string {
+	socket := filepath.Join(dir, "fs513.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/files/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/v1/files/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "no such file missing"}`))
+		case r.Method == http.MethodPatch:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error": "no leader"}`))
+		default:
+			w.Write([]byte(`{"name": "a.txt", "replicas": ["10.0.0.1:6000"]}`))
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return socket
+}
+
+func TestExitCodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fs513-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Unsetenv(ADMIN_ENV)
+	socket := fakeNode(t, dir)
+	source := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(source, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		argv []string
+		code int
+	}{
+		{"put", []string{"-socket", socket, "put", source, "a.txt"}, EXIT_OK},
+		{"stat", []string{"-socket", socket, "stat", "a.txt"}, EXIT_OK},
+		{"unknown command", []string{"-socket", socket, "cat", "a.txt"}, EXIT_USAGE},
+		{"missing argument", []string{"-socket", socket, "setrep", "a.txt"}, EXIT_USAGE},
+		{"replication not a number", []string{"-socket", socket, "put", source, "a.txt", "three"}, EXIT_USAGE},
+		{"put with -addr", []string{"-addr", "127.0.0.1:1", "put", source, "a.txt"}, EXIT_USAGE},
+		{"get with -addr", []string{"-addr", "127.0.0.1:1", "get", "a.txt"}, EXIT_USAGE},
+		{"missing source file", []string{"-socket", socket, "put", filepath.Join(dir, "absent"), "a.txt"}, EXIT_IO},
+		{"source is a directory", []string{"-socket", socket, "put", dir, "a.txt"}, EXIT_IO},
+		{"missing dest directory", []string{"-socket", socket, "get", "a.txt", filepath.Join(dir, "absent", "a")}, EXIT_IO},
+		{"no such file", []string{"-socket", socket, "stat", "missing"}, EXIT_NOT_FOUND},
+		{"refused by the node", []string{"-socket", socket, "setrep", "a.txt", "2"}, EXIT_FAILED},
+		{"no node", []string{"-socket", filepath.Join(dir, "absent.sock"), "stat", "a.txt"}, EXIT_UNAVAILABLE},
+	}
+	for _, c := range cases {
+		if code := run(c.argv, ioutil.Discard, ioutil.Discard); code != c.code {
+			t.Errorf("%s: exit code %d, want %d", c.name, code, c.code)
+		}
+	}
+}
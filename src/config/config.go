@@ -0,0 +1,463 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"utils"
+)
+
+const (
+	CONFIG_ENV = "FS513_CONFIG" // Environment variable holding the config file path
+	ENV_PREFIX = "FS513_"       // Prefix for environment overrides of single settings
+	PORT_VAR   = "{port}"       // Replaced by the base port in storage_path, log_path and control_socket
+
+	DEFAULT_PORT    = 50000
+	MSG_OFFSET      = 0 // Port for listening to messages
+	MG_OFFSET       = 1 // Port of the join handshake with the gateway
+	FL_OFFSET       = 2 // Port for file list updates from the gateway
+	GREP_OFFSET     = 3 // Port of the grep server
+	HTTP_OFFSET     = 4 // Port of the HTTP endpoints, /metrics
+	ADMIN_OFFSET    = 5 // Port of the HTTP admin API, bound to admin_addr
+	TRANSFER_OFFSET = 6 // Port of the file transfer service copying replicas between nodes
+	PORT_SPAN       = 7 // Number of ports a node uses starting at its base port
+
+	MIN_KEY_LEN = 16 // Shortest cluster key accepted
+)
+
+/*
+ * Duration wraps time.Duration so it can be written as "2500ms" or "1s" in the config file
+ */
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"2500ms\": %s", string(b))
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) String() string {
+	return d.Duration.String()
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+/*
+ * Config holds every setting a node needs to run. Values are resolved in the order
+ * defaults -> config file -> environment -> command line flags.
+ */
+type Config struct {
+	ClusterName     string            `json:"cluster_name"`     // Nodes only join a gateway of the same cluster
+	Introducer      string            `json:"introducer"`       // host:port new nodes send their Join to, also the first leader
+	Seeds           []string          `json:"seeds"`            // More host:port members a Join is sent to when the introducer does not answer
+	ListenAddr      string            `json:"listen_addr"`      // Local address all listeners bind to, except the admin API
+	AdminAddr       string            `json:"admin_addr"`       // Local address the admin API binds to, empty disables it
+	ControlSocket   string            `json:"control_socket"`   // Unix socket serving the admin API to local clients, may contain {port}, empty disables it
+	AdvertiseAddr   string            `json:"advertise_addr"`   // Address other nodes use to reach this node
+	Port            int               `json:"port"`             // Base port, the node ID is advertise_addr:port
+	StoragePath     string            `json:"storage_path"`     // Directory holding the fs513 replicas, may contain {port}
+	CleanStorage    bool              `json:"clean_storage"`    // Empty the storage directory when the node starts and after it left, off by default
+	Replication     int               `json:"replication"`      // Default replication factor, replicas kept of a file unless set on put or with setrep
+	MaxVersions     int               `json:"max_versions"`     // Versions kept of every file, older ones are dropped on a put
+	WriteQuorum     int               `json:"write_quorum"`     // Replicas which must store a put before it succeeds, W, capped at the replicas of a file
+	ReadQuorum      int               `json:"read_quorum"`      // Replicas asked for the newest version on a get, R. Both are raised for a file with W+R replicas or more
+	LogPath         string            `json:"log_path"`         // Node log file, also the file searched by grep, may contain {port}
+	ProbeInterval   Duration          `json:"probe_interval"`   // Failure detector protocol period, one member is probed per period
+	AckTimeout      Duration          `json:"ack_timeout"`      // Wait for a direct ACK before probing through other members
+	SuspectTimeout  Duration          `json:"suspect_timeout"`  // Time a suspected member has to refute before it is declared failed
+	IndirectProbes  int               `json:"indirect_probes"`  // Members asked to probe a target which missed its direct ACK
+	RetransmitMult  int               `json:"retransmit_mult"`  // Each membership update is gossiped retransmit_mult * log10(N+1) times
+	MaxPiggyback    int               `json:"max_piggyback"`    // Most membership updates carried by a single msg
+	ElectionTimeout Duration          `json:"election_timeout"` // Time to wait for an Answer before claiming leadership
+	MinGroupSize    int               `json:"min_group_size"`   // Members are only suspected from this group size on
+	Meta            map[string]string `json:"meta"`             // Metadata announced to the group on join, e.g. zone or role
+	ClusterKeys     []string          `json:"cluster_keys"`     // Shared secrets, the first signs msgs and all are accepted
+	ReplayWindow    Duration          `json:"replay_window"`    // Msgs older than this, or from further in the future, are rejected
+}
+
+/*
+ * Default returns the settings the cluster used before they became configurable
+ */
+func Default() *Config {
+	return &Config{
+		ClusterName:     "fs513",
+		ListenAddr:      "0.0.0.0",
+		AdminAddr:       "127.0.0.1",
+		ControlSocket:   "/tmp/fs513-{port}.sock",
+		Port:            DEFAULT_PORT,
+		StoragePath:     "/home/ec2-user/fs513_files/{port}/",
+		CleanStorage:    false,
+		Replication:     3,
+		MaxVersions:     5,
+		WriteQuorum:     2,
+		ReadQuorum:      2,
+		LogPath:         "src/logs/logfile-{port}.log",
+		ProbeInterval:   Duration{time.Second * 1},
+		AckTimeout:      Duration{time.Millisecond * 500},
+		SuspectTimeout:  Duration{time.Second * 5},
+		IndirectProbes:  3,
+		RetransmitMult:  4,
+		MaxPiggyback:    6,
+		ElectionTimeout: Duration{time.Second * 2},
+		MinGroupSize:    4,
+		ReplayWindow:    Duration{time.Second * 30},
+	}
+}
+
+/*
+ * Load builds the configuration from the config file, FS513_* environment variables and the given
+ * command line arguments, and validates the result.
+ */
+func Load(name string, args []string) (*Config, error) {
+	conf := Default()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(CONFIG_ENV), "path to the JSON config file")
+	set := registerFlags(fs, conf)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := conf.ReadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+	if err := conf.applyEnv(); err != nil {
+		return nil, err
+	}
+	// Flags win over the file and the environment, so only the ones given explicitly are applied
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		if apply, ok := set[f.Name]; ok && flagErr == nil {
+			flagErr = apply(f.Value.String())
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if conf.AdvertiseAddr == "" {
+		conf.AdvertiseAddr = utils.GetLocalIP()
+	}
+	// A bare introducer IP refers to a node running on the default port
+	if conf.Introducer != "" && net.ParseIP(conf.Introducer) != nil {
+		conf.Introducer = bind(conf.Introducer, DEFAULT_PORT)
+	}
+	for i, seed := range conf.Seeds {
+		if net.ParseIP(seed) != nil {
+			conf.Seeds[i] = bind(seed, DEFAULT_PORT)
+		}
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	conf.LogPath = strings.Replace(conf.LogPath, PORT_VAR, strconv.Itoa(conf.Port), -1)
+	conf.ControlSocket = strings.Replace(conf.ControlSocket, PORT_VAR, strconv.Itoa(conf.Port), -1)
+	return conf, nil
+}
+
+/*
+ * ReadFile overlays the settings present in the JSON file on top of conf
+ */
+func (conf *Config) ReadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: cannot read %s: %v", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(conf); err != nil {
+		return fmt.Errorf("config: cannot parse %s: %v", path, err)
+	}
+	return nil
+}
+
+/*
+ * Validate checks every setting and reports all problems found at once
+ */
+func (conf *Config) Validate() error {
+	problems := make([]string, 0)
+
+	if conf.ClusterName == "" {
+		problems = append(problems, "cluster_name is not set")
+	}
+
+	if conf.Introducer == "" {
+		problems = append(problems, "introducer is not set")
+	} else if host, port, err := net.SplitHostPort(conf.Introducer); err != nil || net.ParseIP(host) == nil || !validPort(port) {
+		problems = append(problems, "introducer "+conf.Introducer+" is not an ip:port address")
+	}
+	for _, seed := range conf.Seeds {
+		if host, port, err := net.SplitHostPort(seed); err != nil || net.ParseIP(host) == nil || !validPort(port) {
+			problems = append(problems, "seed "+seed+" is not an ip:port address")
+		}
+	}
+	if net.ParseIP(conf.ListenAddr) == nil {
+		problems = append(problems, "listen_addr "+conf.ListenAddr+" is not an IP address")
+	}
+	if conf.AdminAddr != "" && net.ParseIP(conf.AdminAddr) == nil {
+		problems = append(problems, "admin_addr "+conf.AdminAddr+" is not an IP address")
+	}
+	if net.ParseIP(conf.AdvertiseAddr) == nil {
+		problems = append(problems, "advertise_addr \""+conf.AdvertiseAddr+"\" is not an IP address")
+	}
+
+	if conf.Port <= 0 || conf.Port+PORT_SPAN-1 > 65535 {
+		problems = append(problems, fmt.Sprintf("port %d is out of range 1-%d", conf.Port, 65535-PORT_SPAN+1))
+	}
+
+	if conf.StoragePath == "" {
+		problems = append(problems, "storage_path is not set")
+	}
+	if conf.Replication < 1 || conf.Replication > 255 {
+		problems = append(problems, "replication must be between 1 and 255")
+	}
+	if conf.MaxVersions < 1 {
+		problems = append(problems, "max_versions must be at least 1")
+	}
+	if conf.WriteQuorum < 1 {
+		problems = append(problems, "write_quorum must be at least 1")
+	}
+	if conf.ReadQuorum < 1 {
+		problems = append(problems, "read_quorum must be at least 1")
+	}
+	if conf.LogPath == "" {
+		problems = append(problems, "log_path is not set")
+	}
+	if conf.ProbeInterval.Duration <= 0 {
+		problems = append(problems, "probe_interval must be positive")
+	}
+	if conf.AckTimeout.Duration <= 0 {
+		problems = append(problems, "ack_timeout must be positive")
+	}
+	if conf.AckTimeout.Duration >= conf.ProbeInterval.Duration {
+		problems = append(problems, "ack_timeout must be shorter than probe_interval")
+	}
+	if conf.SuspectTimeout.Duration <= 0 {
+		problems = append(problems, "suspect_timeout must be positive")
+	}
+	if conf.IndirectProbes < 0 {
+		problems = append(problems, "indirect_probes must not be negative")
+	}
+	if conf.RetransmitMult < 1 {
+		problems = append(problems, "retransmit_mult must be at least 1")
+	}
+	if conf.MaxPiggyback < 1 {
+		problems = append(problems, "max_piggyback must be at least 1")
+	}
+	if conf.ElectionTimeout.Duration <= 0 {
+		problems = append(problems, "election_timeout must be positive")
+	}
+	if conf.MinGroupSize < 2 {
+		problems = append(problems, "min_group_size must be at least 2")
+	}
+	for _, key := range conf.ClusterKeys {
+		if len(key) < MIN_KEY_LEN {
+			problems = append(problems, fmt.Sprintf("cluster_keys must be at least %d characters long", MIN_KEY_LEN))
+			break
+		}
+	}
+	if conf.ReplayWindow.Duration <= 0 {
+		problems = append(problems, "replay_window must be positive")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+/*
+ * ID returns the identity of this node, advertise_addr:port
+ */
+func (conf *Config) ID() string {
+	return bind(conf.AdvertiseAddr, conf.Port)
+}
+
+/*
+ * ListenOn returns the local address the listener at the given port offset binds to
+ */
+func (conf *Config) ListenOn(offset int) string {
+	return bind(conf.ListenAddr, conf.Port+offset)
+}
+
+/*
+ * AdminOn returns the local address of the admin API
+ */
+func (conf *Config) AdminOn() string {
+	return bind(conf.AdminAddr, conf.Port+ADMIN_OFFSET)
+}
+
+/*
+ * StorageDir returns the storage directory of the node with the given ID, with or without a trailing
+ * slash in storage_path. Every node of a cluster is expected to use the same storage_path, so a {port}
+ * in it keeps nodes sharing a host apart.
+ */
+func (conf *Config) StorageDir(id string) string {
+	_, port, _ := net.SplitHostPort(id)
+	return filepath.Clean(strings.Replace(conf.StoragePath, PORT_VAR, port, -1))
+}
+
+/*
+ * PortAddr returns the address of the port at offset from the base port of the node with the given ID
+ */
+func PortAddr(id string, offset int) string {
+	host, port, err := net.SplitHostPort(id)
+	if err != nil {
+		return id
+	}
+	base, _ := strconv.Atoi(port)
+	return bind(host, base+offset)
+}
+
+/*
+ * HostOf returns the host part of a node ID
+ */
+func HostOf(id string) string {
+	host, _, err := net.SplitHostPort(id)
+	if err != nil {
+		return id
+	}
+	return host
+}
+
+func bind(host string, port int) string {
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+/*
+ * Each setting can be given as flag or as FS513_<NAME> environment variable
+ */
+type setter func(string) error
+
+func setters(conf *Config) map[string]setter {
+	str := func(p *string) setter { return func(v string) error { *p = v; return nil } }
+	num := func(p *int, name string) setter {
+		return func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("config: %s must be a number, got %q", name, v)
+			}
+			*p = n
+			return nil
+		}
+	}
+	boolean := func(p *bool, name string) setter {
+		return func(v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("config: %s must be true or false, got %q", name, v)
+			}
+			*p = b
+			return nil
+		}
+	}
+	dur := func(p *Duration, name string) setter {
+		return func(v string) error {
+			if err := p.Set(v); err != nil {
+				return fmt.Errorf("config: %s must be a duration such as 2500ms, got %q", name, v)
+			}
+			return nil
+		}
+	}
+	meta := func(p *map[string]string) setter {
+		return func(v string) error {
+			m := make(map[string]string)
+			for _, pair := range strings.Split(v, ",") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 || kv[0] == "" {
+					return fmt.Errorf("config: meta must be a list of key=value pairs, got %q", v)
+				}
+				m[kv[0]] = kv[1]
+			}
+			*p = m
+			return nil
+		}
+	}
+	list := func(p *[]string) setter {
+		return func(v string) error { *p = strings.Split(v, ","); return nil }
+	}
+	return map[string]setter{
+		"cluster-name":     str(&conf.ClusterName),
+		"introducer":       str(&conf.Introducer),
+		"seeds":            list(&conf.Seeds),
+		"listen-addr":      str(&conf.ListenAddr),
+		"admin-addr":       str(&conf.AdminAddr),
+		"control-socket":   str(&conf.ControlSocket),
+		"advertise-addr":   str(&conf.AdvertiseAddr),
+		"port":             num(&conf.Port, "port"),
+		"storage-path":     str(&conf.StoragePath),
+		"clean-storage":    boolean(&conf.CleanStorage, "clean-storage"),
+		"replication":      num(&conf.Replication, "replication"),
+		"max-versions":     num(&conf.MaxVersions, "max-versions"),
+		"write-quorum":     num(&conf.WriteQuorum, "write-quorum"),
+		"read-quorum":      num(&conf.ReadQuorum, "read-quorum"),
+		"log-path":         str(&conf.LogPath),
+		"probe-interval":   dur(&conf.ProbeInterval, "probe-interval"),
+		"ack-timeout":      dur(&conf.AckTimeout, "ack-timeout"),
+		"suspect-timeout":  dur(&conf.SuspectTimeout, "suspect-timeout"),
+		"indirect-probes":  num(&conf.IndirectProbes, "indirect-probes"),
+		"retransmit-mult":  num(&conf.RetransmitMult, "retransmit-mult"),
+		"max-piggyback":    num(&conf.MaxPiggyback, "max-piggyback"),
+		"election-timeout": dur(&conf.ElectionTimeout, "election-timeout"),
+		"min-group-size":   num(&conf.MinGroupSize, "min-group-size"),
+		"meta":             meta(&conf.Meta),
+		"cluster-keys":     list(&conf.ClusterKeys),
+		"replay-window":    dur(&conf.ReplayWindow, "replay-window"),
+	}
+}
+
+func registerFlags(fs *flag.FlagSet, conf *Config) map[string]setter {
+	set := setters(conf)
+	for name := range set {
+		fs.String(name, "", "overrides the "+strings.Replace(name, "-", "_", -1)+" config setting")
+	}
+	return set
+}
+
+func (conf *Config) applyEnv() error {
+	for name, apply := range setters(conf) {
+		key := ENV_PREFIX + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+		if v, ok := os.LookupEnv(key); ok {
+			if err := apply(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
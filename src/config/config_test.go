@@ -0,0 +1,87 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorageDir(t *testing.T) {
+	cases := []struct{ path, dir string }{
+		{"/var/fs513/{port}/", "/var/fs513/50010"},
+		{"/var/fs513/{port}", "/var/fs513/50010"},
+		{"/var/fs513", "/var/fs513"},
+		{"files/", "files"},
+	}
+	for _, c := range cases {
+		conf := Default()
+		conf.StoragePath = c.path
+		if dir := conf.StorageDir("10.0.0.1:50010"); dir != c.dir {
+			t.Errorf("storage_path %q gives %q, want %q", c.path, dir, c.dir)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	conf := Default()
+	conf.Introducer = "10.0.0.1"
+	conf.AdvertiseAddr = "10.0.0.2"
+	conf.Replication = 0
+	conf.AckTimeout = conf.ProbeInterval
+	conf.ClusterKeys = []string{"short"}
+	err := conf.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, problem := range []string{"introducer 10.0.0.1", "replication", "ack_timeout", "cluster_keys"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("problem with %s not reported in %q", problem, err)
+		}
+	}
+
+	conf = Default()
+	conf.Introducer = "10.0.0.1:50000"
+	conf.AdvertiseAddr = "10.0.0.2"
+	conf.Replication = 5
+	if err := conf.Validate(); err != nil {
+		t.Errorf("default quorums with replication 5: %v", err)
+	}
+}
+
+/*
+ * The file is overridden by the environment, and both by flags
+ */
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fs513.json")
+	data := `{"introducer": "10.0.0.1", "seeds": ["10.0.0.2", "10.0.0.3:50010"], "advertise_addr": "10.0.0.4",
+		"replication": 2, "max_versions": 3, "write_quorum": 1}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(ENV_PREFIX+"MAX_VERSIONS", "7")
+	os.Setenv(ENV_PREFIX+"WRITE_QUORUM", "2")
+	defer os.Unsetenv(ENV_PREFIX + "MAX_VERSIONS")
+	defer os.Unsetenv(ENV_PREFIX + "WRITE_QUORUM")
+
+	conf, err := Load("test", []string{"-config", path, "-write-quorum", "1", "-port", "50100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Replication != 2 || conf.MaxVersions != 7 || conf.WriteQuorum != 1 {
+		t.Errorf("replication %d, max_versions %d, write_quorum %d, want 2 from the file, 7 from the environment "+
+			"and 1 from the flag", conf.Replication, conf.MaxVersions, conf.WriteQuorum)
+	}
+	// A bare IP refers to a node on the default port
+	if conf.Introducer != "10.0.0.1:50000" || strings.Join(conf.Seeds, ",") != "10.0.0.2:50000,10.0.0.3:50010" {
+		t.Errorf("introducer %s and seeds %v, want the default port added to bare IPs", conf.Introducer, conf.Seeds)
+	}
+	if conf.ControlSocket != "/tmp/fs513-50100.sock" {
+		t.Errorf("control_socket %s, want {port} replaced", conf.ControlSocket)
+	}
+
+	if _, err := Load("test", []string{"-config", path, "-replication", "three"}); err == nil {
+		t.Error("replication given as a word was accepted")
+	}
+}
@@ -0,0 +1,393 @@
+package fs513
+
+import (
+	"config"
+	"encoding/json"
+	"errors"
+	"grepserver"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+ * HTTP/JSON admin API, bound to admin_addr so that by default only tools on the same host reach it, and
+ * served on the control socket for local clients such as the fs513 command and the menu of groupmain:
+ *
+ *   GET    /v1/self               ID, incarnation, state, leader and metadata of this node
+ *   GET    /v1/members            membership list with states and incarnations
+ *   GET    /v1/files?prefix=p     every file, or those starting with p, with the hosts holding a replica
+ *   GET    /v1/files/{name}       one file, its versions, its replicas and whether this node holds one
+ *   PUT    /v1/files/{name}       store the file at local_path of {"local_path": "..."} under name, as a
+ *                                 new version if name exists. "replication": n sets its replicas kept
+ *   PATCH  /v1/files/{name}       set the replicas kept of the file with {"replication": n}
+ *   DELETE /v1/files/{name}       remove the file and its replicas
+ *   POST   /v1/fetch              copy {"name": "...", "dest": "..."} to the local path dest, without
+ *                                 dest only into the storage directory of the node. With "versions": k
+ *                                 the last k versions are written to dest, newest first
+ *   POST   /v1/grep               grep the logs of every member with {"args": [...]}
+ *   GET    /v1/local              files this node holds a replica of
+ *   GET    /v1/metrics            the metrics snapshot as JSON
+ *   POST   /v1/join               join the group, answers with /v1/self
+ *   POST   /v1/leave              leave the group, answers with /v1/self
+ *
+ * Paths in requests are paths on the host of the node. Errors are answered as {"error": "..."} with a 4xx
+ * or 5xx status. The admin API has no authentication, anyone on the host may reach admin_addr, so a PUT
+ * of a file and a fetch to a dest, which read and write paths as the user running the node, are only
+ * served on the control socket and answered with 403 Forbidden on admin_addr.
+ */
+type SelfInfo struct {
+	ID          string            `json:"id"`
+	Incarnation uint64            `json:"incarnation"`
+	State       NodeState         `json:"state"`
+	Leader      string            `json:"leader"`
+	Meta        map[string]string `json:"meta,omitempty"`
+}
+
+type FileInfo struct {
+	Name        string        `json:"name"`
+	Replicas    []string      `json:"replicas"`
+	Replication int           `json:"replication"`    // Replicas wanted
+	Version     uint64        `json:"version"`        // Latest version
+	Checksum    string        `json:"checksum"`       // Hex SHA-256 of the latest version
+	Versions    []VersionInfo `json:"versions"`       // Versions kept, oldest first
+	Local       bool          `json:"local"`          // Whether this node holds a replica
+	Size        int64         `json:"size,omitempty"` // Size of the local replica of the latest version in bytes
+}
+
+type VersionInfo struct {
+	Version  uint64 `json:"version"`
+	Checksum string `json:"checksum"`
+}
+
+type putRequest struct {
+	LocalPath   string `json:"local_path"`
+	Replication int    `json:"replication"` // 0 to keep the replication of the file
+}
+
+type setrepRequest struct {
+	Replication int `json:"replication"`
+}
+
+type fetchRequest struct {
+	Name     string `json:"name"`
+	Dest     string `json:"dest"`
+	Versions int    `json:"versions"` // Number of versions to write, 0 for only the latest
+}
+
+type grepRequest struct {
+	Args []string `json:"args"`
+}
+
+type GrepResult struct {
+	Results []string `json:"results"`         // Output of every member
+	Error   string   `json:"error,omitempty"` // Set when some members did not answer
+}
+
+/*
+ * Self returns what the node knows about itself
+ */
+func (n *Node) Self() SelfInfo {
+	n.mutex.Lock()
+	info := SelfInfo{n.currHost, n.incarnation, n.state, "", n.conf.Meta}
+	n.mutex.Unlock()
+	info.Leader = n.Leader()
+	return info
+}
+
+/*
+ * Stat returns the versions and replicas of fs513_name and the size of the local replica, false if there
+ * is no such file
+ */
+func (n *Node) Stat(fs513_name string) (FileInfo, bool) {
+	n.mutex.Lock()
+	meta, ok := n.fs513_list[fs513_name]
+	latest := meta.latest()
+	info := FileInfo{Name: fs513_name, Replicas: append([]string(nil), meta.Replicas...), Replication: n.replication(meta), Version: latest.Version,
+		Checksum: latest.Checksum, Versions: make([]VersionInfo, 0, len(meta.Versions)), Local: contains(meta.Replicas, n.currHost)}
+	for _, v := range meta.Versions {
+		info.Versions = append(info.Versions, VersionInfo{v.Version, v.Checksum})
+	}
+	n.mutex.Unlock()
+	if !ok {
+		return info, false
+	}
+	if fi, err := os.Stat(n.replicaPath(fs513_name, latest.Version)); err == nil && info.Local {
+		info.Size = fi.Size()
+	}
+	return info, true
+}
+
+/*
+ * Open the listener of the admin API. On failure everything opened so far is closed
+ */
+func (n *Node) listenAdmin() (net.Listener, error) {
+	listener, err := n.trans.Listen(n.conf.AdminOn())
+	if err != nil {
+		n.Stop()
+		return nil, err
+	}
+	n.closers = append(n.closers, listener)
+	return countingListener{listener, n.metrics, portName(config.ADMIN_OFFSET)}, nil
+}
+
+/*
+ * Open the control socket, replacing a socket file left behind by a previous run. Only the user running
+ * the node may connect. On failure everything opened so far is closed
+ */
+func (n *Node) listenControl() (net.Listener, error) {
+	path := n.conf.ControlSocket
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		n.Stop()
+		return nil, errors.New("control socket " + path + " is in use by another node")
+	}
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err == nil {
+		err = os.Chmod(path, 0600)
+	}
+	if err != nil {
+		if listener != nil {
+			listener.Close()
+		}
+		n.Stop()
+		return nil, err
+	}
+	n.closers = append(n.closers, listener)
+	return countingListener{listener, n.metrics, "control"}, nil
+}
+
+/*
+ * Serve the admin API on listener. control tells whether listener is the control socket, on which the
+ * requests taking a local path are allowed
+ */
+func (n *Node) serveAdmin(listener net.Listener, control bool) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/self", n.adminGet(func(r *http.Request) (interface{}, int, error) {
+		return n.Self(), http.StatusOK, nil
+	}))
+	mux.HandleFunc("/v1/members", n.adminGet(func(r *http.Request) (interface{}, int, error) {
+		return n.Members(), http.StatusOK, nil
+	}))
+	mux.HandleFunc("/v1/files", n.adminGet(func(r *http.Request) (interface{}, int, error) {
+		prefix := r.URL.Query().Get("prefix")
+		files := make([]FileInfo, 0)
+		for name := range n.Files() {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if info, ok := n.Stat(name); ok {
+				files = append(files, info)
+			}
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+		return files, http.StatusOK, nil
+	}))
+	mux.HandleFunc("/v1/files/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && !control {
+			n.adminReply(w, http.StatusForbidden, adminError{errControlOnly.Error()})
+			return
+		}
+		n.handleFile(w, r)
+	})
+	mux.HandleFunc("/v1/fetch", n.adminAction(func(r *http.Request) (interface{}, int, error) {
+		req := fetchRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.Versions < 0 {
+			return nil, http.StatusBadRequest, errors.New("expected {\"name\": \"...\", \"dest\": \"...\"}")
+		}
+		if req.Dest != "" && !control {
+			return nil, http.StatusForbidden, errControlOnly
+		}
+		if req.Versions > 0 && req.Dest == "" {
+			return nil, http.StatusBadRequest, errors.New("versions are only written to a dest")
+		}
+		// Not checked against the file list first, a file just put may not be in it yet
+		var err error
+		switch {
+		case req.Versions > 0:
+			err = n.GetVersions(req.Name, req.Versions, req.Dest)
+		case req.Dest == "":
+			err = n.Get(req.Name)
+		default:
+			err = n.Fetch(req.Name, req.Dest)
+		}
+		if _, missing := err.(errNotFound); missing {
+			return nil, http.StatusNotFound, err
+		}
+		if err != nil {
+			return nil, http.StatusBadGateway, err
+		}
+		info, _ := n.Stat(req.Name)
+		return info, http.StatusOK, nil
+	}))
+	mux.HandleFunc("/v1/grep", n.adminAction(func(r *http.Request) (interface{}, int, error) {
+		req := grepRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Args) == 0 {
+			return nil, http.StatusBadRequest, errors.New("expected {\"args\": [...]}")
+		}
+		if _, err := grepserver.CommandLine(req.Args); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		results, err := n.Grep(req.Args)
+		res := GrepResult{Results: results}
+		if err != nil {
+			// Partial results are still worth returning
+			res.Error = err.Error()
+			return res, http.StatusBadGateway, nil
+		}
+		return res, http.StatusOK, nil
+	}))
+	mux.HandleFunc("/v1/local", n.adminGet(func(r *http.Request) (interface{}, int, error) {
+		return n.LocalFiles(), http.StatusOK, nil
+	}))
+	mux.HandleFunc("/v1/metrics", n.adminGet(func(r *http.Request) (interface{}, int, error) {
+		return n.Metrics(), http.StatusOK, nil
+	}))
+	mux.HandleFunc("/v1/join", n.adminPost(STATE_LEFT, n.Join))
+	mux.HandleFunc("/v1/leave", n.adminPost(STATE_ACTIVE, n.Leave))
+
+	server := &http.Server{Handler: mux}
+	if err := server.Serve(listener); err != nil && !n.stopping() {
+		n.errlog.Println("admin:", err)
+	}
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+var errControlOnly = errors.New("requests with a local path are only served on the control socket")
+
+type errNotFound string
+
+func (e errNotFound) Error() string {
+	return "no file " + string(e) + " in FS513"
+}
+
+/*
+ * GET, PUT, PATCH and DELETE of a single file
+ */
+func (n *Node) handleFile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/files/")
+	if name == "" {
+		n.adminReply(w, http.StatusNotFound, adminError{"no file name given"})
+		return
+	}
+	info, exists := n.Stat(name)
+
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			n.adminReply(w, http.StatusNotFound, adminError{errNotFound(name).Error()})
+			return
+		}
+		n.adminReply(w, http.StatusOK, info)
+	case http.MethodPut:
+		req := putRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.LocalPath == "" {
+			n.adminReply(w, http.StatusBadRequest, adminError{"expected {\"local_path\": \"...\"}"})
+			return
+		}
+		if req.Replication != 0 {
+			if err := n.validReplication(req.Replication); err != nil {
+				n.adminReply(w, http.StatusBadRequest, adminError{err.Error()})
+				return
+			}
+		}
+		if err := n.Put(req.LocalPath, name, req.Replication); err != nil {
+			n.adminReply(w, http.StatusInternalServerError, adminError{err.Error()})
+			return
+		}
+		info, _ = n.Stat(name)
+		n.adminReply(w, http.StatusCreated, info)
+	case http.MethodPatch:
+		req := setrepRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			n.adminReply(w, http.StatusBadRequest, adminError{"expected {\"replication\": n}"})
+			return
+		}
+		if err := n.validReplication(req.Replication); err != nil {
+			n.adminReply(w, http.StatusBadRequest, adminError{err.Error()})
+			return
+		}
+		if !exists {
+			n.adminReply(w, http.StatusNotFound, adminError{errNotFound(name).Error()})
+			return
+		}
+		if err := n.SetReplication(name, req.Replication); err != nil {
+			n.adminReply(w, http.StatusBadGateway, adminError{err.Error()})
+			return
+		}
+		info, _ = n.Stat(name)
+		n.adminReply(w, http.StatusOK, info)
+	case http.MethodDelete:
+		if !exists {
+			n.adminReply(w, http.StatusNotFound, adminError{errNotFound(name).Error()})
+			return
+		}
+		if err := n.Remove(name); err != nil {
+			n.adminReply(w, http.StatusInternalServerError, adminError{err.Error()})
+			return
+		}
+		n.adminReply(w, http.StatusOK, info)
+	default:
+		n.adminReply(w, http.StatusMethodNotAllowed, adminError{"use GET, PUT, PATCH or DELETE"})
+	}
+}
+
+func (n *Node) adminGet(handle func(*http.Request) (interface{}, int, error)) http.HandlerFunc {
+	return n.adminMethod(http.MethodGet, handle)
+}
+
+func (n *Node) adminAction(handle func(*http.Request) (interface{}, int, error)) http.HandlerFunc {
+	return n.adminMethod(http.MethodPost, handle)
+}
+
+func (n *Node) adminMethod(method string, handle func(*http.Request) (interface{}, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			n.adminReply(w, http.StatusMethodNotAllowed, adminError{"use " + method})
+			return
+		}
+		v, status, err := handle(r)
+		if err != nil {
+			n.adminReply(w, status, adminError{err.Error()})
+			return
+		}
+		n.adminReply(w, status, v)
+	}
+}
+
+/*
+ * Run a lifecycle change which is only possible from the state from. A node in another state is answered
+ * with 409 Conflict, a failed change with 502 Bad Gateway since it failed talking to
+ * the group
+ */
+func (n *Node) adminPost(from NodeState, change func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			n.adminReply(w, http.StatusMethodNotAllowed, adminError{"use POST"})
+			return
+		}
+		if state := n.State(); state != from {
+			n.adminReply(w, http.StatusConflict, adminError{"node is " + string(state) + ", not " + string(from)})
+			return
+		}
+		if err := change(); err != nil {
+			n.adminReply(w, http.StatusBadGateway, adminError{err.Error()})
+			return
+		}
+		n.adminReply(w, http.StatusOK, n.Self())
+	}
+}
+
+func (n *Node) adminReply(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		n.errlog.Println("admin: writing reply:", err)
+	}
+}
@@ -0,0 +1,114 @@
+package fs513
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"transport"
+)
+
+/*
+ * Serve the admin API of n on a local TCP port and return its base URL. control tells whether it is
+ * served as on the control socket
+ */
+func serveTestAdmin(t *testing.T, n *Node, control bool) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go n.serveAdmin(listener, control)
+	return "http://" + listener.Addr().String()
+}
+
+func adminCall(t *testing.T, base string, method string, path string, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, base+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+func TestAdminAPI(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 3, nil)
+	admin := serveTestAdmin(t, nodes[1], false)
+	control := serveTestAdmin(t, nodes[1], true)
+	local := writeTestFile(t, "admin")
+	dest := filepath.Join(t.TempDir(), "fetched")
+
+	cases := []struct {
+		name         string
+		base, method string
+		path, body   string
+		status       int
+	}{
+		{"self", admin, "GET", "/v1/self", "", http.StatusOK},
+		{"self by POST", admin, "POST", "/v1/self", "", http.StatusMethodNotAllowed},
+		{"put on admin_addr", admin, "PUT", "/v1/files/a.txt", `{"local_path": "` + local + `"}`, http.StatusForbidden},
+		{"put without a path", control, "PUT", "/v1/files/a.txt", `{}`, http.StatusBadRequest},
+		{"put", control, "PUT", "/v1/files/a.txt", `{"local_path": "` + local + `", "replication": 2}`, http.StatusCreated},
+		{"stat", admin, "GET", "/v1/files/a.txt", "", http.StatusOK},
+		{"stat of a missing file", admin, "GET", "/v1/files/missing", "", http.StatusNotFound},
+		{"setrep to 0", admin, "PATCH", "/v1/files/a.txt", `{"replication": 0}`, http.StatusBadRequest},
+		{"setrep of a missing file", admin, "PATCH", "/v1/files/missing", `{"replication": 2}`, http.StatusNotFound},
+		{"fetch to a dest on admin_addr", admin, "POST", "/v1/fetch", `{"name": "a.txt", "dest": "` + dest + `"}`, http.StatusForbidden},
+		{"fetch of a missing file", admin, "POST", "/v1/fetch", `{"name": "missing"}`, http.StatusNotFound},
+		{"fetch", control, "POST", "/v1/fetch", `{"name": "a.txt", "dest": "` + dest + `"}`, http.StatusOK},
+		{"versions without a dest", admin, "POST", "/v1/fetch", `{"name": "a.txt", "versions": 2}`, http.StatusBadRequest},
+		{"grep with a refused flag", admin, "POST", "/v1/grep", `{"args": ["-r", "x", "/etc"]}`, http.StatusBadRequest},
+		{"join while active", admin, "POST", "/v1/join", "", http.StatusConflict},
+		{"metrics", admin, "GET", "/v1/metrics", "", http.StatusOK},
+	}
+	for _, c := range cases {
+		status, data := adminCall(t, c.base, c.method, c.path, c.body)
+		if status != c.status {
+			t.Errorf("%s: status %d, want %d (%s)", c.name, status, c.status, strings.TrimSpace(string(data)))
+		}
+	}
+	if content, err := ioutil.ReadFile(dest); err != nil || string(content) != "admin" {
+		t.Errorf("fetched %q, %v, want \"admin\"", content, err)
+	}
+
+	_, data := adminCall(t, admin, "GET", "/v1/files?prefix=a", "")
+	files := make([]FileInfo, 0)
+	if err := json.Unmarshal(data, &files); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "a.txt" || files[0].Replication != 2 {
+		t.Errorf("files with prefix a are %+v, want a.txt with replication 2", files)
+	}
+	_, data = adminCall(t, admin, "GET", "/v1/members", "")
+	members := make([]Member, 0)
+	if err := json.Unmarshal(data, &members); err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 3 {
+		t.Errorf("%d members listed, want 3", len(members))
+	}
+
+	if status, data := adminCall(t, admin, "POST", "/v1/leave", ""); status != http.StatusOK {
+		t.Fatalf("leave: status %d (%s)", status, data)
+	}
+	self := SelfInfo{}
+	_, data = adminCall(t, admin, "GET", "/v1/self", "")
+	if err := json.Unmarshal(data, &self); err != nil {
+		t.Fatal(err)
+	}
+	if self.ID != nodes[1].ID() || self.State != STATE_LEFT {
+		t.Errorf("self after leaving is %s %s, want %s %s", self.ID, self.State, nodes[1].ID(), STATE_LEFT)
+	}
+}
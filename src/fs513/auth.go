@@ -0,0 +1,149 @@
+package fs513
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Every frame, see wire.go, is authenticated with an HMAC-SHA256 over the cluster key. The timestamp
+ * bounds how long a captured frame stays valid and the nonce makes each frame usable only once within
+ * that window.
+ *
+ * Keys are rotated without downtime: add the new key at the end of cluster_keys on every node, then move
+ * it to the front (the first key signs, all keys verify), then drop the old one. fs513d applies each step
+ * on SIGHUP, see SetClusterKeys.
+ */
+var (
+	errBadMAC   = errors.New("bad message authentication code")
+	errExpired  = errors.New("timestamp outside the replay window")
+	errReplayed = errors.New("nonce already seen")
+)
+
+/*
+ * Keys and the nonces seen within the replay window
+ */
+type authenticator struct {
+	mutex     sync.Mutex
+	keys      [][]byte
+	window    time.Duration
+	nonces    map[uint64]int64 // Nonce -> timestamp of the frame which carried it
+	lastPurge time.Time
+	rejected  uint64 // Frames rejected so far, updated atomically
+}
+
+func newAuthenticator(keys []string, window time.Duration) *authenticator {
+	a := &authenticator{window: window, nonces: make(map[uint64]int64), lastPurge: time.Now()}
+	a.setKeys(keys)
+	return a
+}
+
+func (a *authenticator) setKeys(keys []string) {
+	list := make([][]byte, 0)
+	for _, k := range keys {
+		list = append(list, []byte(k))
+	}
+	a.mutex.Lock()
+	a.keys = list
+	a.mutex.Unlock()
+}
+
+/*
+ * Build a frame of type t carrying payload, signed with the first key
+ */
+func (a *authenticator) seal(t msgType, id uint64, payload []byte) ([]byte, error) {
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	data := encodeHeader(t, id, time.Now().UnixNano(), binary.BigEndian.Uint64(nonce[:]), payload)
+
+	a.mutex.Lock()
+	var sum []byte
+	if len(a.keys) > 0 {
+		sum = mac(a.keys[0], data)
+	}
+	a.mutex.Unlock()
+
+	data = append(data, uint8(len(sum)))
+	return append(data, sum...), nil
+}
+
+/*
+ * Decode and check a frame. Rejected frames are counted
+ */
+func (a *authenticator) open(data []byte) (frame, error) {
+	f, err := decodeFrame(data)
+	if err != nil {
+		return f, a.reject(err)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if len(a.keys) > 0 {
+		valid := false
+		for _, key := range a.keys {
+			valid = valid || hmac.Equal(f.MAC, mac(key, f.signed))
+		}
+		if !valid {
+			return f, a.reject(errBadMAC)
+		}
+	}
+
+	now := time.Now()
+	age := now.Sub(time.Unix(0, f.Timestamp))
+	if age > a.window || age < -a.window {
+		return f, a.reject(errExpired)
+	}
+	if _, ok := a.nonces[f.Nonce]; ok {
+		return f, a.reject(errReplayed)
+	}
+	a.nonces[f.Nonce] = f.Timestamp
+
+	if now.Sub(a.lastPurge) > a.window {
+		for nonce, ts := range a.nonces {
+			if now.Sub(time.Unix(0, ts)) > a.window {
+				delete(a.nonces, nonce)
+			}
+		}
+		a.lastPurge = now
+	}
+	return f, nil
+}
+
+func (a *authenticator) reject(err error) error {
+	atomic.AddUint64(&a.rejected, 1)
+	return err
+}
+
+func (a *authenticator) rejectedCount() uint64 {
+	return atomic.LoadUint64(&a.rejected)
+}
+
+func mac(key []byte, signed []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(signed)
+	return h.Sum(nil)
+}
+
+/*
+ * SetClusterKeys replaces the keys of a running node, the first key signs and every key verifies
+ */
+func (n *Node) SetClusterKeys(keys []string) {
+	n.auth.setKeys(keys)
+}
+
+/*
+ * RejectedPackets returns the number of msgs and state transfers dropped because they failed
+ * authentication, were replayed, came with an unsupported version or type or could not be decoded
+ */
+func (n *Node) RejectedPackets() uint64 {
+	return n.auth.rejectedCount()
+}
@@ -0,0 +1,56 @@
+package fs513
+
+import (
+	"io/ioutil"
+	"testing"
+	"transport"
+)
+
+/*
+ * A replica corrupted on disk is not served. The node reading it gets an intact copy from another replica,
+ * and the leader has a good copy made in its place
+ */
+func TestCorruptReplicaIsRepaired(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 4, nil)
+	if err := nodes[1].Put(writeTestFile(t, "intact"), "a.txt", 3); err != nil {
+		t.Fatal(err)
+	}
+	intact := func() bool {
+		replicas := nodes[0].Locate("a.txt")
+		for _, n := range nodes {
+			if !contains(replicas, n.ID()) {
+				continue
+			}
+			versions := n.localVersions("a.txt")
+			if len(versions) == 0 {
+				return false
+			}
+			content, err := ioutil.ReadFile(n.replicaPath("a.txt", versions[len(versions)-1]))
+			if err != nil || string(content) != "intact" {
+				return false
+			}
+		}
+		return len(replicas) == 3
+	}
+	waitFor(t, "every replica of a.txt to hold it", intact)
+
+	var corrupted *Node
+	for _, n := range nodes[1:] {
+		if contains(n.Locate("a.txt"), n.ID()) {
+			corrupted = n
+			break
+		}
+	}
+	versions := corrupted.localVersions("a.txt")
+	if err := ioutil.WriteFile(corrupted.replicaPath("a.txt", versions[len(versions)-1]), []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fetchContent(t, corrupted, "a.txt"); got != "intact" {
+		t.Errorf("fetched %q from a node with a corrupt replica, want \"intact\"", got)
+	}
+	if corrupted.Metrics().Transfers.BadReplicas == 0 {
+		t.Error("no bad replica counted")
+	}
+	waitFor(t, "a.txt to have 3 intact replicas again", intact)
+}
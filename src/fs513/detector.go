@@ -0,0 +1,249 @@
+package fs513
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+/*
+ * SWIM style failure detector. Every probe_interval one member is picked from a shuffled round robin
+ * list and sent a SYN. Without an ACK after ack_timeout, indirect_probes other members are asked with a
+ * PingReq to probe it on our behalf. Without any ACK by the end of the interval the member becomes
+ * SUSPECT and is only declared Failed if it does not refute the suspicion within suspect_timeout.
+ * Probing runs at any group size since it also carries the gossip, suspicion starts at min_group_size.
+ */
+const (
+	ALIVE   = "Alive"
+	SUSPECT = "Suspect"
+)
+
+/*
+ * Probe one member per protocol period until the node is stopped
+ */
+func (n *Node) probeMembers() {
+	for {
+		start := time.Now()
+		n.mutex.Lock()
+		size := len(n.membershipGroup)
+		n.mutex.Unlock()
+		n.metrics.sampleSize(size)
+		n.expireRemovals()
+		if target := n.nextProbeTarget(); target != "" {
+			n.probe(target)
+		}
+		select {
+		case <-n.done:
+			return
+		case <-time.After(n.conf.ProbeInterval.Duration - time.Since(start)):
+		}
+	}
+}
+
+/*
+ * Pick the next member to probe. Every member is probed once per round, in a new random order each round
+ */
+func (n *Node) nextProbeTarget() string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for len(n.probeOrder) > 0 {
+		target := n.probeOrder[0]
+		n.probeOrder = n.probeOrder[1:]
+		if n.getIdxOfHost(target) != -1 {
+			return target
+		}
+	}
+	for _, i := range rand.Perm(len(n.membershipGroup)) {
+		if n.membershipGroup[i].Host != n.currHost {
+			n.probeOrder = append(n.probeOrder, n.membershipGroup[i].Host)
+		}
+	}
+	if len(n.probeOrder) == 0 {
+		return ""
+	}
+	target := n.probeOrder[0]
+	n.probeOrder = n.probeOrder[1:]
+	return target
+}
+
+func (n *Node) probe(target string) {
+	seq, ack := n.newProbe()
+	defer n.closeProbe(seq)
+
+	msg := message{Host: n.currHost, Type: MSG_SYN, Seq: seq, Updates: n.piggyback()}
+	sent := time.Now()
+	n.sendToHosts(msg, []string{target})
+	select {
+	case <-ack:
+		n.metrics.ackReceived(target, time.Since(sent))
+		return
+	case <-time.After(n.conf.AckTimeout.Duration):
+	}
+
+	// No direct ACK, ask k other members to probe the target for us
+	helpers := n.randomMembers(n.conf.IndirectProbes, target)
+	msg = message{Host: n.currHost, Type: MSG_PING_REQ, Seq: seq, Target: target, Updates: n.piggyback()}
+	n.sendToHosts(msg, helpers)
+	select {
+	case <-ack:
+		n.metrics.ackReceived(target, 0)
+		return
+	case <-time.After(n.conf.ProbeInterval.Duration - n.conf.AckTimeout.Duration):
+	}
+
+	n.mutex.Lock()
+	size := len(n.membershipGroup)
+	n.mutex.Unlock()
+	if size >= n.conf.MinGroupSize {
+		n.suspect(target)
+	}
+}
+
+/*
+ * This function sends back the ACK to the host which sent SYN to it.
+ */
+func (n *Node) respondAck(pkt message) {
+	msg := message{Host: n.currHost, Type: MSG_ACK, Seq: pkt.Seq, Updates: n.piggyback()}
+	n.sendToHosts(msg, []string{pkt.Host})
+}
+
+/*
+ * Probe pkt.Target on behalf of pkt.Host and relay the ACK back to it
+ */
+func (n *Node) probeFor(pkt message) {
+	seq, ack := n.newProbe()
+	defer n.closeProbe(seq)
+
+	msg := message{Host: n.currHost, Type: MSG_SYN, Seq: seq, Updates: n.piggyback()}
+	n.sendToHosts(msg, []string{pkt.Target})
+	select {
+	case <-ack:
+		msg = message{Host: pkt.Target, Type: MSG_ACK, Seq: pkt.Seq, Updates: n.piggyback()}
+		n.sendToHosts(msg, []string{pkt.Host})
+	case <-time.After(n.conf.AckTimeout.Duration):
+	}
+}
+
+func (n *Node) ackReceived(pkt message) {
+	n.ackMutex.Lock()
+	if ch, ok := n.pendingAcks[pkt.Seq]; ok {
+		signal(ch)
+	}
+	n.ackMutex.Unlock()
+}
+
+func (n *Node) newProbe() (uint64, chan bool) {
+	n.ackMutex.Lock()
+	defer n.ackMutex.Unlock()
+	n.probeSeq++
+	ch := make(chan bool, 1)
+	n.pendingAcks[n.probeSeq] = ch
+	return n.probeSeq, ch
+}
+
+func (n *Node) closeProbe(seq uint64) {
+	n.ackMutex.Lock()
+	delete(n.pendingAcks, seq)
+	n.ackMutex.Unlock()
+}
+
+/*
+ * Pick up to k random members other than the current host and exclude
+ */
+func (n *Node) randomMembers(k int, exclude string) []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	hosts := make([]string, 0)
+	for _, i := range rand.Perm(len(n.membershipGroup)) {
+		if len(hosts) == k {
+			break
+		}
+		host := n.membershipGroup[i].Host
+		if host != n.currHost && host != exclude {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+/*
+ * Mark the target SUSPECT and gossip it, the target refutes once the update reaches it
+ */
+func (n *Node) suspect(target string) {
+	n.mutex.Lock()
+	ix := n.getIdxOfHost(target)
+	if ix == -1 || n.membershipGroup[ix].State == SUSPECT {
+		n.mutex.Unlock()
+		return
+	}
+	n.membershipGroup[ix].State = SUSPECT
+	n.startSuspicion(target)
+	n.publish(EVENT_SUSPECT, n.membershipGroup[ix])
+	u := update{target, SUSPECT, n.membershipGroup[ix].Incarnation, nil}
+	n.mutex.Unlock()
+
+	n.infolog.Println("Suspecting host: " + target)
+	n.enqueueUpdate(u)
+}
+
+/*
+ * Announce that we are alive with an incarnation higher than the one we are suspected with
+ */
+func (n *Node) refute(suspected uint64) {
+	n.mutex.Lock()
+	ix := n.getIx()
+	if ix == -1 || suspected < n.incarnation {
+		n.mutex.Unlock()
+		return
+	}
+	n.incarnation = suspected + 1
+	n.membershipGroup[ix].Incarnation = n.incarnation
+	u := update{n.currHost, ALIVE, n.incarnation, n.conf.Meta}
+	n.mutex.Unlock()
+	n.saveIncarnation(u.Incarnation)
+
+	n.infolog.Println("Refuting suspicion with incarnation " + strconv.FormatUint(u.Incarnation, 10))
+	n.enqueueUpdate(u)
+}
+
+/*
+ * Start the suspicion timeout for host. Must be called with mutex held
+ */
+func (n *Node) startSuspicion(host string) {
+	if _, ok := n.suspectTimers[host]; ok {
+		return
+	}
+	n.suspectTimers[host] = time.AfterFunc(n.conf.SuspectTimeout.Duration, func() {
+		n.suspicionExpired(host)
+	})
+}
+
+/*
+ * Stop the suspicion timeout for host. Must be called with mutex held
+ */
+func (n *Node) stopSuspicion(host string) {
+	if t, ok := n.suspectTimers[host]; ok {
+		t.Stop()
+		delete(n.suspectTimers, host)
+	}
+}
+
+/*
+ * The host did not refute in time, declare it Failed and gossip the failure
+ */
+func (n *Node) suspicionExpired(host string) {
+	n.mutex.Lock()
+	delete(n.suspectTimers, host)
+	ix := n.getIdxOfHost(host)
+	if ix == -1 || n.membershipGroup[ix].State != SUSPECT {
+		n.mutex.Unlock()
+		return
+	}
+	u := update{host, "Failed", n.membershipGroup[ix].Incarnation, nil}
+	n.mutex.Unlock()
+
+	n.infolog.Println("Failure detected at host: " + host)
+	n.applyUpdate(u)
+}
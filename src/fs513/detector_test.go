@@ -0,0 +1,61 @@
+package fs513
+
+import (
+	"config"
+	"testing"
+	"time"
+	"transport"
+)
+
+func TestDetectorRemovesCrashedMember(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 4, nil)
+	crashed := nodes[3]
+	crashed.Stop()
+
+	for _, n := range nodes[:3] {
+		waitFor(t, n.ID()+" to remove "+crashed.ID(), func() bool {
+			return !hasMember(n, crashed.ID())
+		})
+	}
+}
+
+func TestDetectorRemovesPartitionedMember(t *testing.T) {
+	network := transport.NewNetwork()
+	nodes := startCluster(t, network, 4, nil)
+	network.Partition([]string{testHost(2)}, []string{testHost(0), testHost(1), testHost(3)})
+
+	for _, i := range []int{0, 1, 3} {
+		n := nodes[i]
+		waitFor(t, n.ID()+" to remove "+testID(2), func() bool {
+			return !hasMember(n, testID(2))
+		})
+	}
+}
+
+func TestDetectorSurvivesLossyLinks(t *testing.T) {
+	network := transport.NewNetwork()
+	nodes := startCluster(t, network, 4, nil)
+	// A lost SYN or ACK is covered by the indirect probes, and a suspicion by the refutation
+	network.SetDefaultLink(transport.Link{Loss: 10})
+
+	time.Sleep(2 * time.Second)
+	for _, n := range nodes {
+		if members := len(n.Members()); members != 4 {
+			t.Errorf("%s has %d members on a lossy network, want 4", n.ID(), members)
+		}
+	}
+}
+
+func TestDetectorDoesNotSuspectInSmallGroups(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 3, func(conf *config.Config) {
+		conf.MinGroupSize = 4
+	})
+	nodes[2].Stop()
+
+	time.Sleep(10 * nodes[0].conf.ProbeInterval.Duration)
+	for _, n := range nodes[:2] {
+		if !hasMember(n, nodes[2].ID()) {
+			t.Errorf("%s removed %s below min_group_size", n.ID(), nodes[2].ID())
+		}
+	}
+}
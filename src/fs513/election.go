@@ -0,0 +1,185 @@
+package fs513
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+ * Bully election over the membership ring. The member with the highest ID is the coordinator and takes
+ * over the gateway role: it handles Join, hands out and records file versions, deletes files and
+ * re-replicates files after failures.
+ * Every node starts with the configured introducer as leader and learns the new one from Coordinator msgs.
+ */
+
+func (n *Node) initLeader() {
+	n.leader = n.conf.Introducer
+}
+
+func (n *Node) getLeader() string {
+	n.electionMutex.Lock()
+	defer n.electionMutex.Unlock()
+	return n.leader
+}
+
+func (n *Node) isLeader() bool {
+	return n.getLeader() == n.currHost
+}
+
+/*
+ * Compare two member IDs of the form ip:port. IPs are compared byte by byte so that 172.31.9.1 is lower
+ * than 172.31.23.202, nodes sharing an IP are ordered by port
+ */
+func higherID(a string, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	ipA, ipB := net.ParseIP(hostA), net.ParseIP(hostB)
+	if errA != nil || errB != nil || ipA == nil || ipB == nil {
+		return a > b
+	}
+	if c := bytes.Compare(ipA.To16(), ipB.To16()); c != 0 {
+		return c > 0
+	}
+	numA, _ := strconv.Atoi(portA)
+	numB, _ := strconv.Atoi(portB)
+	return numA > numB
+}
+
+/*
+ * Start an election unless one is already running. Election msgs go to every member with a higher ID,
+ * if none of them answers within election_timeout this node becomes the coordinator.
+ */
+func (n *Node) startElection() {
+	n.electionMutex.Lock()
+	if n.electing {
+		n.electionMutex.Unlock()
+		return
+	}
+	n.electing = true
+	n.electionMutex.Unlock()
+
+	defer func() {
+		n.electionMutex.Lock()
+		n.electing = false
+		n.electionMutex.Unlock()
+	}()
+
+	for {
+		higher := make([]string, 0)
+		n.mutex.Lock()
+		for _, element := range n.membershipGroup {
+			if higherID(element.Host, n.currHost) {
+				higher = append(higher, element.Host)
+			}
+		}
+		n.mutex.Unlock()
+
+		if len(higher) == 0 {
+			n.becomeLeader()
+			return
+		}
+
+		drain(n.answerCh)
+		drain(n.coordCh)
+		n.infolog.Println("Starting election, asking ", higher)
+		msg := message{Host: n.currHost, Type: MSG_ELECTION}
+		n.sendToHosts(msg, higher)
+
+		select {
+		case <-n.answerCh:
+		case <-n.coordCh:
+			return
+		case <-time.After(n.conf.ElectionTimeout.Duration):
+			n.becomeLeader()
+			return
+		}
+
+		// A higher node took over the election, wait for its Coordinator msg and retry if it never comes
+		select {
+		case <-n.coordCh:
+			return
+		case <-time.After(2 * n.conf.ElectionTimeout.Duration):
+			n.infolog.Println("No coordinator announced, restarting election")
+		}
+	}
+}
+
+/*
+ * Take over the gateway role, announce it to every member and rebuild the file list
+ */
+func (n *Node) becomeLeader() {
+	n.electionMutex.Lock()
+	oldLeader := n.leader
+	n.leader = n.currHost
+	n.electionMutex.Unlock()
+
+	n.infolog.Println("Elected as leader TS - " + time.Now().Format(time.StampMicro))
+
+	msg := message{Host: n.currHost, Type: MSG_COORDINATOR}
+	n.sendToHosts(msg, n.otherMembers())
+
+	// The file list replica received from the old leader is the starting point, drop every host which left
+	go n.updateFileList(oldLeader)
+}
+
+/*
+ * Handle the election msgs: Election, Answer and Coordinator
+ */
+func (n *Node) processElectionMsg(pkt message) {
+	switch pkt.Type {
+	case MSG_ELECTION:
+		if higherID(n.currHost, pkt.Host) {
+			msg := message{Host: n.currHost, Type: MSG_ANSWER}
+			n.sendToHosts(msg, []string{pkt.Host})
+			go n.startElection()
+		}
+	case MSG_ANSWER:
+		signal(n.answerCh)
+	case MSG_COORDINATOR:
+		n.electionMutex.Lock()
+		n.leader = pkt.Host
+		n.electionMutex.Unlock()
+		signal(n.coordCh)
+		n.infolog.Println("New leader: " + pkt.Host)
+	}
+}
+
+/*
+ * Called once a failed or left host has been removed from the membership list. Losing the leader starts
+ * an election, any other loss is repaired by the leader.
+ */
+func (n *Node) memberRemoved(host string) {
+	if host == n.getLeader() {
+		go n.startElection()
+	} else if n.isLeader() {
+		go n.updateFileList(host)
+	}
+}
+
+func (n *Node) otherMembers() []string {
+	hosts := make([]string, 0)
+	n.mutex.Lock()
+	for _, element := range n.membershipGroup {
+		if element.Host != n.currHost {
+			hosts = append(hosts, element.Host)
+		}
+	}
+	n.mutex.Unlock()
+	return hosts
+}
+
+func signal(ch chan bool) {
+	select {
+	case ch <- true:
+	default:
+	}
+}
+
+func drain(ch chan bool) {
+	select {
+	case <-ch:
+	default:
+	}
+}
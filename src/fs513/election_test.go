@@ -0,0 +1,64 @@
+package fs513
+
+import (
+	"testing"
+	"transport"
+)
+
+func TestHigherID(t *testing.T) {
+	cases := []struct {
+		a, b   string
+		higher bool
+	}{
+		{"172.31.23.202:50000", "172.31.9.1:50000", true},
+		{"172.31.9.1:50000", "172.31.23.202:50000", false},
+		{"127.0.0.1:50010", "127.0.0.1:50000", true},
+		{"127.0.0.1:9000", "127.0.0.1:50000", false},
+		{"127.0.0.1:50000", "127.0.0.1:50000", false},
+	}
+	for _, c := range cases {
+		if got := higherID(c.a, c.b); got != c.higher {
+			t.Errorf("higherID(%s, %s) = %v, want %v", c.a, c.b, got, c.higher)
+		}
+	}
+}
+
+func waitForLeader(t *testing.T, nodes []*Node, leader string) {
+	t.Helper()
+	for _, n := range nodes {
+		waitFor(t, n.ID()+" to follow leader "+leader, func() bool {
+			return n.Self().Leader == leader
+		})
+	}
+}
+
+func TestElectionAfterLeaderFails(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 4, nil)
+
+	// The introducer leads until it fails, then the member with the highest ID takes over
+	nodes[0].Stop()
+	waitForLeader(t, nodes[1:], testID(3))
+
+	nodes[3].Stop()
+	waitForLeader(t, nodes[1:3], testID(2))
+}
+
+func TestRestartedIntroducerRejoins(t *testing.T) {
+	network := transport.NewNetwork()
+	nodes := startCluster(t, network, 4, nil)
+	nodes[0].Stop()
+	waitForLeader(t, nodes[1:], testID(3))
+	for _, n := range nodes[1:] {
+		waitForMembers(t, n, 3)
+	}
+
+	// Joins through the seeds and takes the elected leader instead of forming a group of its own
+	introducer := startTestNode(t, network, 0, 4, nil)
+	if leader := introducer.Self().Leader; leader != testID(3) {
+		t.Errorf("restarted introducer follows %s, want %s", leader, testID(3))
+	}
+	waitForLeader(t, nodes[1:], testID(3))
+	for _, n := range append(nodes[1:], introducer) {
+		waitForMembers(t, n, 4)
+	}
+}
@@ -0,0 +1,165 @@
+package fs513
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * Membership events. Every change to the membership list is published to the subscribers in the order
+ * it was applied. Each subscriber has its own unbounded queue, so a slow subscriber neither blocks the
+ * protocol nor loses or reorders events, it only falls behind.
+ */
+type EventType string
+
+const (
+	EVENT_JOIN    EventType = "Join"    // A member was added, or rejoined with a higher incarnation
+	EVENT_LEAVE   EventType = "Leave"   // A member left the group voluntarily. About the local node, the list was reset to it alone
+	EVENT_FAILED  EventType = "Failed"  // A member was declared failed
+	EVENT_SUSPECT EventType = "Suspect" // A member missed its probes and has suspect_timeout to refute
+	EVENT_ALIVE   EventType = "Alive"   // A suspected member refuted the suspicion
+)
+
+type Event struct {
+	Type   EventType
+	Member Member // Record of the member after the change, including its metadata
+	Time   time.Time
+}
+
+/*
+ * Subscription delivers membership events on C until Close is called or the node stops, then C is closed
+ */
+type Subscription struct {
+	C <-chan Event
+
+	node   *Node
+	ch     chan Event
+	mutex  sync.Mutex
+	queue  []Event
+	wake   chan struct{} // Signalled when the queue grows
+	done   chan struct{} // Closed by Close
+	closed bool
+}
+
+/*
+ * Subscribe returns a subscription receiving every membership event from now on
+ */
+func (n *Node) Subscribe() *Subscription {
+	_, sub := n.SubscribeWithSnapshot()
+	return sub
+}
+
+/*
+ * SubscribeWithSnapshot returns the current membership list together with a subscription receiving
+ * every event after it. Applying the events to the snapshot keeps an exact copy of the list.
+ */
+func (n *Node) SubscribeWithSnapshot() ([]Member, *Subscription) {
+	sub := &Subscription{
+		node:  n,
+		ch:    make(chan Event),
+		queue: make([]Event, 0),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	sub.C = sub.ch
+
+	n.mutex.Lock()
+	snapshot := make([]Member, len(n.membershipGroup))
+	for i, m := range n.membershipGroup {
+		snapshot[i] = m.copy()
+	}
+	if n.stopping() {
+		close(sub.done)
+		sub.closed = true
+	} else {
+		n.subscribers = append(n.subscribers, sub)
+	}
+	n.mutex.Unlock()
+
+	go sub.deliver()
+	return snapshot, sub
+}
+
+/*
+ * Close stops the delivery of events. Events still queued are dropped
+ */
+func (s *Subscription) Close() {
+	n := s.node
+	n.mutex.Lock()
+	for i, sub := range n.subscribers {
+		if sub == s {
+			n.subscribers = append(n.subscribers[:i], n.subscribers[i+1:]...)
+			break
+		}
+	}
+	n.mutex.Unlock()
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+func (s *Subscription) push(e Event) {
+	s.mutex.Lock()
+	s.queue = append(s.queue, e)
+	s.mutex.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) deliver() {
+	defer close(s.ch)
+	for {
+		s.mutex.Lock()
+		if len(s.queue) == 0 {
+			s.mutex.Unlock()
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		e := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		select {
+		case s.ch <- e:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+/*
+ * Publish an event about m to every subscriber. Must be called with mutex held, so that events are
+ * queued in the order the changes were applied
+ */
+func (n *Node) publish(t EventType, m Member) {
+	if len(n.subscribers) == 0 {
+		return
+	}
+	e := Event{t, m.copy(), time.Now()}
+	for _, sub := range n.subscribers {
+		sub.push(e)
+	}
+}
+
+/*
+ * Close every subscription. Must be called with mutex held
+ */
+func (n *Node) closeSubscriptions() {
+	for _, sub := range n.subscribers {
+		sub.close()
+	}
+	n.subscribers = nil
+}
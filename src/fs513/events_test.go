@@ -0,0 +1,76 @@
+package fs513
+
+import (
+	"config"
+	"testing"
+	"time"
+	"transport"
+)
+
+func nextEvent(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case e, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return e
+	case <-time.After(TEST_TIMEOUT):
+		t.Fatal("no event")
+	}
+	return Event{}
+}
+
+/*
+ * A subscriber applying the events to the snapshot sees joins with their metadata, leaves and failures in
+ * the order they happened, even when it only reads them afterwards
+ */
+func TestSubscriptionFollowsMembership(t *testing.T) {
+	network := transport.NewNetwork()
+	nodes := startCluster(t, network, 2, nil)
+	snapshot, sub := nodes[0].SubscribeWithSnapshot()
+	if len(snapshot) != 2 {
+		t.Fatalf("snapshot of %d members, want 2", len(snapshot))
+	}
+
+	joining := startTestNode(t, network, 2, 4, func(conf *config.Config) {
+		conf.Meta = map[string]string{"zone": "b"}
+	})
+	leaving := startTestNode(t, network, 3, 4, nil)
+	waitForMembers(t, nodes[0], 4)
+	if err := leaving.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	waitForMembers(t, nodes[0], 3)
+	joining.Stop()
+
+	want := []struct {
+		typ  EventType
+		host string
+	}{
+		{EVENT_JOIN, joining.ID()},
+		{EVENT_JOIN, leaving.ID()},
+		{EVENT_LEAVE, leaving.ID()},
+		{EVENT_SUSPECT, joining.ID()},
+		{EVENT_FAILED, joining.ID()},
+	}
+	for _, w := range want {
+		e := nextEvent(t, sub)
+		if e.Type != w.typ || e.Member.Host != w.host {
+			t.Fatalf("event %s of %s, want %s of %s", e.Type, e.Member.Host, w.typ, w.host)
+		}
+		if e.Member.Host == joining.ID() && e.Member.Meta["zone"] != "b" {
+			t.Errorf("%s event of %s carries zone %q, want \"b\"", e.Type, e.Member.Host, e.Member.Meta["zone"])
+		}
+	}
+
+	sub.Close()
+	select {
+	case _, ok := <-sub.C:
+		if ok {
+			t.Error("event delivered after Close")
+		}
+	case <-time.After(TEST_TIMEOUT):
+		t.Error("C not closed by Close")
+	}
+}
@@ -0,0 +1,514 @@
+package fs513
+
+import (
+	"config"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAX_REPLICATION  = 255            // Most copies kept of a file, the replication is sent as a uint8
+	INCARNATION_FILE = ".incarnation" // Last incarnation of the node, kept in the storage directory
+)
+
+/*
+ * Entry of a file in the file list. The checksum of a version is taken by the node it was put on and
+ * checked on every copy and read of a replica
+ */
+type fileMeta struct {
+	Replicas    []string      // Hosts holding a replica of every version
+	Replication int           // Replicas wanted, fewer while the group is smaller
+	Versions    []fileVersion // Versions kept, oldest first
+}
+
+func (m fileMeta) copy() fileMeta {
+	return fileMeta{append([]string(nil), m.Replicas...), m.Replication, append([]fileVersion(nil), m.Versions...)}
+}
+
+/*
+ * Replicas wanted of the file described by m, replication_factor if it was never set
+ */
+func (n *Node) replication(m fileMeta) int {
+	if m.Replication == 0 {
+		return n.conf.Replication
+	}
+	return m.Replication
+}
+
+/*
+ * A replication a file may be given. Any replica count gets overlapping quorums, see quorums
+ */
+func (n *Node) validReplication(replication int) error {
+	if replication < 1 || replication > MAX_REPLICATION {
+		return errors.New("replication must be between 1 and " + strconv.Itoa(MAX_REPLICATION))
+	}
+	return nil
+}
+
+/*
+ * Create the storage directory, removing the replicas of a previous run if clean_storage is set
+ */
+func (n *Node) initFS() error {
+	if err := os.MkdirAll(n.storageDir, os.ModePerm); err != nil {
+		return err
+	}
+	if n.conf.CleanStorage {
+		return n.removeReplicas()
+	}
+	return nil
+}
+
+/*
+ * Remove every replica from the storage directory. The saved incarnation is kept, see newIncarnation
+ */
+func (n *Node) removeReplicas() error {
+	names, err := filepath.Glob(filepath.Join(n.storageDir, "*"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if filepath.Base(name) == INCARNATION_FILE {
+			continue
+		}
+		if err := os.RemoveAll(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * Store local_path as the next version of fs513_name, see versions.go. A replication other than 0 is set
+ * on the file first
+ */
+func (n *Node) addFileToFS(local_path string, fs513_name string, replication int) error {
+	if !validName(fs513_name) {
+		return errors.New("invalid file name " + fs513_name)
+	}
+	if replication != 0 {
+		if err := n.validReplication(replication); err != nil {
+			return err
+		}
+	}
+
+	// Copied in before asking for a version, so a missing local file costs no version number
+	if err := os.MkdirAll(n.fileDir(fs513_name), os.ModePerm); err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(n.fileDir(fs513_name), ".put-"+strconv.FormatUint(n.nextRequestID(), 10))
+	if err := execCommand("cp", local_path, tmpPath); err != nil {
+		return errors.New("Not able to copy " + local_path + ": " + err.Error())
+	}
+	checksum, _, err := fileChecksum(tmpPath)
+	var grant versionGrant
+	if err == nil {
+		grant, err = n.reserveVersion(fs513_name, replication)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, n.replicaPath(fs513_name, grant.Version))
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = n.writeQuorum(fs513_name, grant.Version, grant.Replicas, func() {
+		if !contains(grant.Replicas, n.currHost) {
+			os.Remove(n.replicaPath(fs513_name, grant.Version))
+			os.Remove(n.fileDir(fs513_name))
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := n.commitVersion(fs513_name, fileVersion{grant.Version, checksum}, replication); err != nil {
+		return err
+	}
+	n.infolog.Println("file " + fs513_name + " version " + strconv.FormatUint(grant.Version, 10) + " added from " + n.currHost)
+	return nil
+}
+
+func (n *Node) deleteFileFromFS(fs513_name string) error {
+	n.mutex.Lock()
+	_, ok := n.fs513_list[fs513_name]
+	n.mutex.Unlock()
+	if !ok {
+		return errors.New("File " + fs513_name + " does not exists in FS513 system")
+	}
+	// Send Delete msg to Gateway
+	if !n.isLeader() {
+		n.sendUpdGateway(fs513_name, MSG_DEL_FILE)
+	} else {
+		n.dropFile(fs513_name)
+	}
+	return nil
+}
+
+/*
+ * Run by the leader: forget fs513_name along with the versions reserved for it, have its replicas remove
+ * their copies and broadcast the file list
+ */
+func (n *Node) dropFile(fs513_name string) {
+	n.mutex.Lock()
+	replicas := n.fs513_list[fs513_name].Replicas
+	delete(n.fs513_list, fs513_name)
+	delete(n.reservations, fs513_name)
+	n.mutex.Unlock()
+
+	msg := message{Host: n.currHost, Type: MSG_RM_FILE, FS513Name: fs513_name}
+	n.sendToHosts(msg, replicas)
+	n.broadcastFileList()
+}
+
+func (n *Node) removeFileFromFS(fs513_name string){
+	// Remove file from directory, with every version of it
+	if !validName(fs513_name) {
+		return
+	}
+	if err := execCommand("rm", "-rf", n.fileDir(fs513_name)); err != nil {
+		n.errlog.Println("Not able to remove "+fs513_name+":", err)
+		return
+	}
+	n.infolog.Println("File " + fs513_name + " removed from " + n.currHost)
+}
+
+/*
+ * Files this node holds a replica of according to the file list. Must be called with mutex held
+ */
+func (n *Node) getLocalFiles() []string {
+	localfiles := make([]string,0)
+	for filename, meta := range n.fs513_list {
+		for _, ip := range meta.Replicas {
+			if ip == n.currHost {
+				localfiles = append(localfiles,filename)
+			}
+		}
+	}
+	return localfiles
+}
+
+/*
+ * Files with fewer replicas on members than their replication, or than the group size in a smaller group.
+ * Must be called with mutex held
+ */
+func (n *Node) underReplicated() []string {
+	files := make([]string, 0)
+	for filename, meta := range n.fs513_list {
+		want := n.replication(meta)
+		if len(n.membershipGroup) < want {
+			want = len(n.membershipGroup)
+		}
+		alive := 0
+		for _, ip := range meta.Replicas {
+			if n.getIdxOfHost(ip) != -1 {
+				alive++
+			}
+		}
+		if alive < want {
+			files = append(files, filename)
+		}
+	}
+	return files
+}
+
+/*
+ * Get the newest version of fs513_name a read quorum of its replicas knows into the storage directory
+ * and return its path
+ */
+func (n *Node) getFileFromDest(fs513_name string) (string, error) {
+	meta, listed := n.fileEntry(fs513_name)
+	if listed && len(meta.Replicas) == 0 {
+		return "", errors.New("File " + fs513_name + " does not exist in FS513 system")
+	}
+	newest, holders, err := n.newestVersion(fs513_name, meta)
+	if !listed && (err != nil || newest.Version == 0) {
+		return "", errNotFound(fs513_name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return n.getVersion(fs513_name, newest, holders)
+}
+
+/*
+ * Pull version of fs513_name into the storage directory from the first of replicas which has an intact
+ * copy, unless this node holds one already. A local copy not matching the checksum is dropped and reported
+ */
+func (n *Node) getVersion(fs513_name string, version fileVersion, replicas []string) (string, error) {
+	path := n.replicaPath(fs513_name, version.Version)
+	if checksum, _, err := fileChecksum(path); err == nil {
+		if checksum == version.Checksum {
+			return path, nil
+		}
+		n.reportBadReplica(fs513_name, version.Version, checksum)
+	}
+
+	err := errors.New("no replica of " + fs513_name + " is reachable")
+	for _, host := range replicas {
+		if host == n.currHost {
+			continue
+		}
+		if err = n.pullFile(fs513_name, version.Version, host); err == nil {
+			return path, nil
+		}
+		n.errlog.Println("Not able to get "+fs513_name+" from "+host+":", err)
+	}
+	return "", err
+}
+
+/*
+ * Copy the latest version of fs513_name to dest, getting it from the replicas first if this node has no copy
+ */
+func (n *Node) fetchFile(fs513_name string, dest string) error {
+	path, err := n.getFileFromDest(fs513_name)
+	if err != nil {
+		return err
+	}
+	if err := execCommand("cp", path, dest); err != nil {
+		return errors.New("Not able to copy " + fs513_name + " to " + dest + ": " + err.Error())
+	}
+	return nil
+}
+
+/*
+ * Run by the leader once hostip has left the group or reported a bad replica, or the replication of a
+ * file changed. Every file is brought back to its replication, see repairReplicas.
+ */
+func (n *Node) updateFileList(hostip string){
+	n.mutex.Lock()
+	for filename, meta := range n.fs513_list {
+		newFileIps := n.repairReplicas(filename, meta)
+		if len(newFileIps) == 0 {
+			n.errlog.Println("File " + filename + " lost, no replica left")
+			delete(n.fs513_list, filename)
+			continue
+		}
+		// update f3513 list
+		meta.Replicas = newFileIps
+		n.fs513_list[filename] = meta
+	}
+	n.mutex.Unlock()
+	n.broadcastFileList()
+}
+
+/*
+ * Replicas of filename once hosts which are no longer members are dropped and the file has its
+ * replication again: it is copied to the successors of its remaining replicas, or the replicas beyond
+ * its replication are removed. Empty if no replica is left. Must be called with mutex held
+ */
+func (n *Node) repairReplicas(filename string, meta fileMeta) []string {
+	newFileIps := make([]string,0)
+	for _, ip := range meta.Replicas {
+		if n.getIdxOfHost(ip) != -1 {
+			newFileIps = append(newFileIps, ip)
+		}
+	}
+	if len(newFileIps) == 0 {
+		return newFileIps
+	}
+	want := n.replication(meta)
+	for len(newFileIps) < want && len(newFileIps) < len(n.membershipGroup) {
+		// Determine new ip, the first successor of the last replica which does not hold the file yet
+		targetIp := newFileIps[len(newFileIps)-1]
+		for contains(newFileIps, targetIp) {
+			targetIp = n.membershipGroup[(n.getIdxOfHost(targetIp)+1)%len(n.membershipGroup)].Host
+		}
+		// Ask one of the remaining replicas to copy the file
+		msg := message{Host: targetIp, Type: MSG_REPLICATE_FILE, FS513Name: filename}
+		n.sendToHosts(msg, newFileIps[:1])
+		newFileIps = append(newFileIps,targetIp)
+	}
+	for len(newFileIps) > want {
+		// Drop the last replica, the uploader and its successors stay
+		msg := message{Host: n.currHost, Type: MSG_RM_FILE, FS513Name: filename}
+		n.sendToHosts(msg, newFileIps[len(newFileIps)-1:])
+		newFileIps = newFileIps[:len(newFileIps)-1]
+	}
+	return newFileIps
+}
+
+/*
+ * Run by the leader once host joined. If the file list still names it as a replica it restarted before
+ * it was found failed, maybe with its storage emptied by clean_storage or lost. Every file it no longer
+ * holds the latest version of is copied back to it from another replica
+ */
+func (n *Node) restoreReplicas(host string) {
+	n.mutex.Lock()
+	files := make(map[string]fileMeta)
+	for name, meta := range n.fs513_list {
+		if contains(meta.Replicas, host) {
+			files[name] = meta.copy()
+		}
+	}
+	n.mutex.Unlock()
+
+	for name, meta := range files {
+		header, err := n.queryNewest(name, host)
+		sources := without(meta.Replicas, host)
+		if (err == nil && header.Version >= meta.latest().Version) || len(sources) == 0 {
+			continue
+		}
+		n.infolog.Println("Copying " + name + " back to " + host + ", which rejoined without it")
+		msg := message{Host: host, Type: MSG_REPLICATE_FILE, FS513Name: name}
+		n.sendToHosts(msg, sources[:1])
+	}
+}
+
+type replicationRequest struct {
+	Name        string
+	Replication int
+}
+
+/*
+ * Run by the leader: set the replication of a file and copy or remove replicas to match it
+ */
+func (n *Node) handleSetReplication(req *replicationRequest) gatewayResponse {
+	if !n.isLeader() {
+		return gatewayResponse{Redirect: n.getLeader()}
+	}
+	if err := n.validReplication(req.Replication); err != nil {
+		return gatewayResponse{Error: err.Error()}
+	}
+
+	n.mutex.Lock()
+	meta, ok := n.fs513_list[req.Name]
+	if ok {
+		meta.Replication = req.Replication
+		n.fs513_list[req.Name] = meta
+	}
+	n.mutex.Unlock()
+	if !ok {
+		return gatewayResponse{Error: "File " + req.Name + " does not exist in FS513 system"}
+	}
+	n.infolog.Println("Replication of " + req.Name + " set to " + strconv.Itoa(req.Replication))
+	n.updateFileList("")
+	return gatewayResponse{}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func without(list []string, s string) []string {
+	rest := make([]string, 0, len(list))
+	for _, v := range list {
+		if v != s {
+			rest = append(rest, v)
+		}
+	}
+	return rest
+}
+
+/*
+ * Drop the local copy of version of fs513_name, found with the checksum bad which does not match the
+ * file list. A good copy pulled in its place in the meantime is kept. If this node is one of the replicas
+ * the leader is told, so it has a good replica copied in its place
+ */
+func (n *Node) reportBadReplica(fs513_name string, version uint64, bad string) {
+	n.errlog.Println("Replica of " + fs513_name + " version " + strconv.FormatUint(version, 10) + " on " + n.currHost +
+		" does not match its checksum, dropping it")
+	n.metrics.badReplica()
+	path := n.replicaPath(fs513_name, version)
+	if checksum, _, err := fileChecksum(path); err == nil && checksum == bad {
+		os.Remove(path)
+	}
+
+	n.mutex.Lock()
+	holder := contains(n.fs513_list[fs513_name].Replicas, n.currHost)
+	n.mutex.Unlock()
+	if holder {
+		n.sendUpdGateway(fs513_name, MSG_BAD_REPLICA)
+	}
+}
+
+/*
+ * Run by the leader: take host out of the replicas of fs513_name and copy a good replica in its place
+ */
+func (n *Node) replaceBadReplica(fs513_name string, host string) {
+	n.mutex.Lock()
+	meta, ok := n.fs513_list[fs513_name]
+	if ok {
+		meta.Replicas = without(meta.Replicas, host)
+		n.fs513_list[fs513_name] = meta
+	}
+	n.mutex.Unlock()
+	if ok {
+		n.infolog.Println("Replacing bad replica of " + fs513_name + " on " + host)
+		n.updateFileList(host)
+	}
+}
+
+func (n *Node) sendUpdGateway(fs513_name string, t msgType) {
+	msg := message{Host: n.currHost, Type: t, FS513Name: fs513_name}
+	var targetHosts = make([]string, 1)
+	targetHosts[0] = n.getLeader()
+
+	n.sendToHosts(msg, targetHosts)
+}
+
+/*
+ * Listen to fs513 file list updates send from Gateway node.
+ */
+func (n *Node) listenToGatewayFL(listener net.Listener) {
+	newList := func() interface{} { return &fileList{} }
+	n.acceptState(listener, "listen gateway", newList, func(v interface{}) interface{} {
+		rcvd_list := v.(*fileList)
+
+		n.mutex.Lock()
+		if rcvd_list.Version < n.fileListVersion {
+			n.mutex.Unlock()
+			return nil
+		}
+		n.fileListVersion = rcvd_list.Version
+		n.fs513_list = rcvd_list.Files
+		n.mutex.Unlock()
+
+		n.infolog.Println("File List Received: ", rcvd_list.Files)
+		n.pruneVersions()
+		return nil
+	})
+}
+
+/*
+ * Send the file list to every other member, each over its own stream so one slow member does not hold
+ * up the rest. A new version number lets receivers drop lists arriving out of order
+ */
+func (n *Node) broadcastFileList() {
+	n.mutex.Lock()
+	n.fileListVersion++
+	list := fileList{n.fileListVersion, make(map[string]fileMeta)}
+	for k, v := range n.fs513_list {
+		list.Files[k] = v.copy()
+	}
+	n.mutex.Unlock()
+
+	for _, host := range n.otherMembers() {
+		go func(host string) {
+			if err := n.sendState(host, config.FL_OFFSET, list); err != nil {
+				n.errlog.Println("broadcastFileList: not able to send the file list to "+host+":", err)
+			}
+		}(host)
+	}
+}
+
+/*
+ * Run cmd, its output is returned as the error if it fails
+ */
+func execCommand(cmd string, cmdArgs ...string) error {
+	cmdOut, err := exec.Command(cmd, cmdArgs...).CombinedOutput()
+	if err != nil && len(cmdOut) > 0 {
+		return errors.New(strings.TrimSpace(string(cmdOut)))
+	}
+	return err
+}
@@ -0,0 +1,239 @@
+package fs513
+
+import (
+	"config"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Requests to the gateway which need an answer go over a stream to its mg_port. A gateway which is not
+ * the leader redirects the request to the leader.
+ *
+ * Join handshake: the joining node sends its cluster name, protocol version and member record to any
+ * member it knows, see joinCandidates, which redirects it to the leader. The leader checks both and
+ * answers with the membership list and leader, or with an error. Every other member learns about the
+ * joiner through gossip.
+ */
+const (
+	MAX_GATEWAY_REDIRECTS = 3                                // Redirects followed before a request gives up
+	GATEWAY_NO_GROUP      = "gateway is not part of a group" // Start of the answer of a gateway which is Left or Joining
+)
+
+var errNoGroup = errors.New("join failed, no member answered")
+
+/*
+ * The gateway a request was first sent to could not be reached or is not part of a group, as opposed to
+ * rejecting the request. After every node of a cluster was restarted each of them answers, but there is no
+ * group left to join
+ */
+type noAnswerError struct {
+	gateway string
+	err     error
+}
+
+func (e noAnswerError) Error() string {
+	return "no answer from gateway " + e.gateway + ": " + e.err.Error()
+}
+
+/*
+ * Exactly one of the requests is set
+ */
+type gatewayRequest struct {
+	Join        *joinRequest
+	Handoff     *handoffRequest
+	Version     *versionRequest
+	Replication *replicationRequest
+	Commit      *commitRequest
+}
+
+type gatewayResponse struct {
+	Error    string // Why the request was rejected, empty on success
+	Redirect string // Leader to retry with when the gateway is not the leader
+	Join     *joinResponse
+	Version  *versionGrant
+}
+
+type joinRequest struct {
+	ClusterName string
+	Version     int    // WIRE_VERSION of the joining node
+	MinVersion  int    // Its MIN_WIRE_VERSION
+	Member      Member // Record of the joining node, its incarnation and metadata
+}
+
+type joinResponse struct {
+	Leader  string
+	Members []Member
+}
+
+/*
+ * Send req to gateway and follow redirects until the leader answers. A rejection is returned as error
+ */
+func (n *Node) gatewayRequest(gateway string, req gatewayRequest) (gatewayResponse, error) {
+	for i := 0; i <= MAX_GATEWAY_REDIRECTS; i++ {
+		resp := gatewayResponse{}
+		if err := n.request(gateway, config.MG_OFFSET, req, &resp); err != nil {
+			if i == 0 {
+				return resp, noAnswerError{gateway, err}
+			}
+			return resp, fmt.Errorf("no answer from gateway %s: %v", gateway, err)
+		}
+		if i == 0 && strings.HasPrefix(resp.Error, GATEWAY_NO_GROUP) {
+			return resp, noAnswerError{gateway, errors.New(resp.Error)}
+		}
+		if resp.Error != "" {
+			return resp, fmt.Errorf("rejected by %s: %s", gateway, resp.Error)
+		}
+		if resp.Redirect == "" {
+			return resp, nil
+		}
+		n.infolog.Println("Request redirected from " + gateway + " to leader " + resp.Redirect)
+		gateway = resp.Redirect
+	}
+	return gatewayResponse{}, errors.New("too many redirects, last gateway " + gateway)
+}
+
+/*
+ * Run the join handshake with the first candidate which answers and take over the membership list it
+ * returns. Returns errNoGroup if none of the candidates could be reached or is part of a group
+ */
+func (n *Node) gatewayConnect() error {
+	n.mutex.Lock()
+	req := joinRequest{n.conf.ClusterName, WIRE_VERSION, MIN_WIRE_VERSION, n.membershipGroup[n.getIx()].copy()}
+	n.mutex.Unlock()
+
+	var last error
+	for _, host := range n.joinCandidates() {
+		resp, err := n.gatewayRequest(host, gatewayRequest{Join: &req})
+		if err != nil {
+			n.errlog.Println("Join through "+host+" failed:", err)
+			if _, unreachable := err.(noAnswerError); !unreachable {
+				last = err
+			}
+			continue
+		}
+		n.joinedGroup(resp.Join.Members, resp.Join.Leader)
+		return nil
+	}
+	if last == nil {
+		return errNoGroup
+	}
+	return errors.New("join " + last.Error())
+}
+
+/*
+ * Hosts a Join is sent to, in order: the leader last known, the introducer, the seeds and the members of
+ * the last group this node left. Whichever of them is alive forwards the Join to the current leader
+ */
+func (n *Node) joinCandidates() []string {
+	n.mutex.Lock()
+	known := append([]string(nil), n.knownMembers...)
+	n.mutex.Unlock()
+
+	hosts := append([]string{n.getLeader(), n.conf.Introducer}, n.conf.Seeds...)
+	candidates := make([]string, 0)
+	for _, host := range append(hosts, known...) {
+		if host != n.currHost && !contains(candidates, host) {
+			candidates = append(candidates, host)
+		}
+	}
+	return candidates
+}
+
+/*
+ * Take over the membership list received from the gateway
+ */
+func (n *Node) joinedGroup(list []Member, leader string) {
+	n.mutex.Lock()
+	old := n.membershipGroup
+	n.membershipGroup = list
+	for _, m := range list {
+		known := false
+		for _, element := range old {
+			known = known || element.Host == m.Host
+		}
+		if !known {
+			n.publish(EVENT_JOIN, m)
+		}
+	}
+	n.mutex.Unlock()
+
+	n.electionMutex.Lock()
+	n.leader = leader
+	n.electionMutex.Unlock()
+
+	n.infolog.Println("Joined the group, " + strconv.Itoa(len(list)) + " members, leader " + leader)
+}
+
+/*
+ * Answer the requests sent to the mg_port
+ */
+func (n *Node) listenToGateway(listener net.Listener) {
+	newRequest := func() interface{} { return &gatewayRequest{} }
+	n.acceptState(listener, "listen gateway", newRequest, func(v interface{}) interface{} {
+		req := v.(*gatewayRequest)
+		if state := n.State(); state == STATE_LEFT || state == STATE_JOINING {
+			// Only a member of the group answers for it, a node which is still joining might be on its own
+			return gatewayResponse{Error: GATEWAY_NO_GROUP + ", it is " + string(state)}
+		}
+		switch {
+		case req.Join != nil:
+			return n.handleJoin(req.Join)
+		case req.Handoff != nil:
+			return n.handleHandoff(req.Handoff)
+		case req.Version != nil:
+			return n.handleVersionRequest(req.Version)
+		case req.Replication != nil:
+			return n.handleSetReplication(req.Replication)
+		case req.Commit != nil:
+			return n.handleCommit(req.Commit)
+		}
+		return gatewayResponse{Error: "unknown request"}
+	})
+}
+
+func (n *Node) handleJoin(req *joinRequest) gatewayResponse {
+	host := req.Member.Host
+	if req.ClusterName != n.conf.ClusterName {
+		n.errlog.Println("Rejected Join from " + host + " for cluster " + strconv.Quote(req.ClusterName))
+		return gatewayResponse{Error: "cluster name mismatch, gateway is in cluster " + strconv.Quote(n.conf.ClusterName) +
+			" but the node is configured for " + strconv.Quote(req.ClusterName)}
+	}
+	if req.Version < MIN_WIRE_VERSION || req.MinVersion > WIRE_VERSION {
+		n.errlog.Println("Rejected Join from " + host + " with protocol versions " + strconv.Itoa(req.MinVersion) + " to " +
+			strconv.Itoa(req.Version))
+		return gatewayResponse{Error: "protocol version mismatch, gateway reads versions " + strconv.Itoa(MIN_WIRE_VERSION) +
+			" on and speaks " + strconv.Itoa(WIRE_VERSION) + " but the node reads versions " + strconv.Itoa(req.MinVersion) +
+			" on and speaks " + strconv.Itoa(req.Version)}
+	}
+	if !n.isLeader() {
+		return gatewayResponse{Redirect: n.getLeader()}
+	}
+
+	node := req.Member
+	node.State = ALIVE
+	n.mutex.Lock()
+	added := n.mergeMember(node)
+	// A retried handshake whose first answer got lost finds the joiner already in the list
+	ix := n.getIdxOfHost(node.Host)
+	retried := !added && ix != -1 && n.membershipGroup[ix].Incarnation == node.Incarnation
+	list := make([]Member, len(n.membershipGroup))
+	for i, m := range n.membershipGroup {
+		list[i] = m.copy()
+	}
+	n.mutex.Unlock()
+	if !added && !retried {
+		n.infolog.Println("Ignoring Join from " + host + " with stale incarnation " + strconv.FormatUint(node.Incarnation, 10))
+		return gatewayResponse{Error: "incarnation " + strconv.FormatUint(node.Incarnation, 10) + " is not newer than the one the group knows"}
+	}
+
+	if added {
+		n.enqueueUpdate(update{node.Host, ALIVE, node.Incarnation, node.Meta})
+		go n.broadcastFileList()
+		go n.restoreReplicas(node.Host)
+	}
+	return gatewayResponse{Join: &joinResponse{n.currHost, list}}
+}
@@ -0,0 +1,156 @@
+package fs513
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+/*
+ * Epidemic dissemination of membership updates. Every change to the membership list is queued and
+ * piggybacked on the SYN, ACK and PingReq msgs of the failure detector. Each update is sent
+ * retransmit_mult * log(N+1) times, after which every member has received it with high probability.
+ */
+type update struct {
+	Host        string
+	Status      string // ALIVE, SUSPECT, Failed or Leave
+	Incarnation uint64
+	Meta        map[string]string // Metadata of Host, carried by ALIVE so that members joining by gossip get it
+}
+
+type queuedUpdate struct {
+	u         update
+	transmits int
+}
+
+/*
+ * Queue an update for dissemination. A newer update about the same host replaces the queued one
+ */
+func (n *Node) enqueueUpdate(u update) {
+	n.gossipMutex.Lock()
+	defer n.gossipMutex.Unlock()
+
+	for i, q := range n.gossipQueue {
+		if q.u.Host == u.Host {
+			n.gossipQueue = append(n.gossipQueue[:i], n.gossipQueue[i+1:]...)
+			break
+		}
+	}
+	n.gossipQueue = append(n.gossipQueue, &queuedUpdate{u, 0})
+}
+
+/*
+ * Take up to max_piggyback updates to attach to an outgoing msg, the least transmitted first.
+ * Updates which reached the retransmit limit are dropped from the queue.
+ */
+func (n *Node) piggyback() []update {
+	limit := n.retransmitLimit()
+
+	n.gossipMutex.Lock()
+	defer n.gossipMutex.Unlock()
+
+	sort.SliceStable(n.gossipQueue, func(i, j int) bool {
+		return n.gossipQueue[i].transmits < n.gossipQueue[j].transmits
+	})
+	updates := make([]update, 0)
+	for _, q := range n.gossipQueue {
+		if len(updates) == n.conf.MaxPiggyback {
+			break
+		}
+		updates = append(updates, q.u)
+		q.transmits++
+	}
+	kept := n.gossipQueue[:0]
+	for _, q := range n.gossipQueue {
+		if q.transmits < limit {
+			kept = append(kept, q)
+		}
+	}
+	n.gossipQueue = kept
+	return updates
+}
+
+func (n *Node) retransmitLimit() int {
+	n.mutex.Lock()
+	size := len(n.membershipGroup)
+	n.mutex.Unlock()
+	return n.conf.RetransmitMult * int(math.Ceil(math.Log10(float64(size+1))))
+}
+
+func (n *Node) applyUpdates(updates []update) {
+	for _, u := range updates {
+		n.applyUpdate(u)
+	}
+}
+
+/*
+ * Apply an update received from another member and queue it again if it changed our list, so that it
+ * keeps spreading. Conflicts are resolved by incarnation:
+ * ALIVE overrides older incarnations, as a refutation of a suspected member or else as a rejoin which
+ * replaces the record, SUSPECT overrides ALIVE of the same or an older incarnation and
+ * SUSPECT of an older one, Failed and Leave override everything up to their incarnation.
+ */
+func (n *Node) applyUpdate(u update) {
+	if u.Host == n.currHost {
+		if u.Status == SUSPECT {
+			n.refute(u.Incarnation)
+		} else if u.Status == "Failed" {
+			n.mutex.Lock()
+			current := n.incarnation
+			n.mutex.Unlock()
+			if u.Incarnation >= current {
+				n.errlog.Println("Declared failed by the group with incarnation " + strconv.FormatUint(u.Incarnation, 10))
+			}
+		}
+		return
+	}
+
+	changed, removed := false, false
+	n.mutex.Lock()
+	ix := n.getIdxOfHost(u.Host)
+	switch u.Status {
+	case ALIVE:
+		if ix == -1 {
+			if n.mergeMember(Member{u.Host, u.Incarnation, ALIVE, u.Meta}) {
+				changed = true
+				n.infolog.Println("New VM joined the group: (" + u.Host + " | " + strconv.FormatUint(u.Incarnation, 10) + ")")
+			}
+		} else if local := n.membershipGroup[ix]; u.Incarnation > local.Incarnation && local.State == SUSPECT {
+			n.membershipGroup[ix] = Member{u.Host, u.Incarnation, ALIVE, u.Meta}
+			n.stopSuspicion(u.Host)
+			n.publish(EVENT_ALIVE, n.membershipGroup[ix])
+			changed = true
+			n.infolog.Println("Host refuted suspicion: " + u.Host)
+		} else if n.mergeMember(Member{u.Host, u.Incarnation, ALIVE, u.Meta}) {
+			// A member which was not suspected restarted, with metadata which may have changed
+			changed = true
+			n.infolog.Println("VM rejoined the group: (" + u.Host + " | " + strconv.FormatUint(u.Incarnation, 10) + ")")
+		}
+	case SUSPECT:
+		if ix != -1 {
+			local := n.membershipGroup[ix]
+			if (local.State == ALIVE && u.Incarnation >= local.Incarnation) || u.Incarnation > local.Incarnation {
+				n.membershipGroup[ix].Incarnation = u.Incarnation
+				n.membershipGroup[ix].State = SUSPECT
+				n.startSuspicion(u.Host)
+				n.publish(EVENT_SUSPECT, n.membershipGroup[ix])
+				changed = true
+				n.infolog.Println("Host suspected: " + u.Host)
+			}
+		}
+	case "Failed", "Leave":
+		if ix != -1 && u.Incarnation >= n.membershipGroup[ix].Incarnation {
+			n.stopSuspicion(u.Host)
+			n.updateMG(ix, u)
+			changed, removed = true, true
+		}
+	}
+	n.mutex.Unlock()
+
+	if changed {
+		n.enqueueUpdate(u)
+	}
+	if removed {
+		n.memberRemoved(u.Host)
+	}
+}
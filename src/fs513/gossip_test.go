@@ -0,0 +1,145 @@
+package fs513
+
+import (
+	"testing"
+	"time"
+	"transport"
+)
+
+func memberRecord(n *Node, host string) (Member, bool) {
+	for _, m := range n.Members() {
+		if m.Host == host {
+			return m, true
+		}
+	}
+	return Member{}, false
+}
+
+/*
+ * Updates about one member are applied in order, each checked against the record left by the ones before
+ */
+func TestIncarnationConflicts(t *testing.T) {
+	// Neither node is the leader, so a removal does not start an election or a file list repair
+	n := newTestNode(t, transport.NewNetwork(), 1, 3, nil)
+	t.Cleanup(n.Stop)
+	peer := testID(2)
+	n.applyUpdate(update{peer, ALIVE, 10, nil})
+
+	steps := []struct {
+		name        string
+		u           update
+		present     bool
+		state       string
+		incarnation uint64
+	}{
+		{"older Alive is ignored", update{peer, ALIVE, 9, nil}, true, ALIVE, 10},
+		{"older Suspect is ignored", update{peer, SUSPECT, 9, nil}, true, ALIVE, 10},
+		{"Suspect overrides Alive of the same incarnation", update{peer, SUSPECT, 10, nil}, true, SUSPECT, 10},
+		{"Alive of the same incarnation does not refute", update{peer, ALIVE, 10, nil}, true, SUSPECT, 10},
+		{"newer Alive refutes", update{peer, ALIVE, 11, nil}, true, ALIVE, 11},
+		{"newer Suspect overrides Suspect", update{peer, SUSPECT, 12, nil}, true, SUSPECT, 12},
+		{"older Failed is ignored", update{peer, "Failed", 11, nil}, true, SUSPECT, 12},
+		{"Failed removes the member", update{peer, "Failed", 12, nil}, false, "", 0},
+		{"Alive of the removed incarnation is stale", update{peer, ALIVE, 12, nil}, false, "", 0},
+		{"newer Alive rejoins", update{peer, ALIVE, 13, nil}, true, ALIVE, 13},
+		{"Leave removes the member", update{peer, "Leave", 13, nil}, false, "", 0},
+	}
+	for _, step := range steps {
+		n.applyUpdate(step.u)
+		m, present := memberRecord(n, peer)
+		if present != step.present {
+			t.Fatalf("%s: member present %v, want %v", step.name, present, step.present)
+		}
+		if present && (m.State != step.state || m.Incarnation != step.incarnation) {
+			t.Fatalf("%s: member is %s with incarnation %d, want %s with %d",
+				step.name, m.State, m.Incarnation, step.state, step.incarnation)
+		}
+	}
+}
+
+/*
+ * A newer incarnation of a member not suspected is a restart, which replaces the record along with its
+ * metadata, while one of a suspected member refutes the suspicion
+ */
+func TestRejoinAndRefutationEvents(t *testing.T) {
+	n := newTestNode(t, transport.NewNetwork(), 1, 3, nil)
+	t.Cleanup(n.Stop)
+	peer := testID(2)
+	sub := n.Subscribe()
+
+	steps := []struct {
+		u     update
+		event EventType
+	}{
+		{update{peer, ALIVE, 10, map[string]string{"zone": "a"}}, EVENT_JOIN},
+		{update{peer, ALIVE, 11, map[string]string{"zone": "b"}}, EVENT_JOIN},
+		{update{peer, SUSPECT, 11, nil}, EVENT_SUSPECT},
+		{update{peer, ALIVE, 12, map[string]string{"zone": "c"}}, EVENT_ALIVE},
+	}
+	for _, step := range steps {
+		n.applyUpdate(step.u)
+		select {
+		case e := <-sub.C:
+			if e.Type != step.event || e.Member.Incarnation != step.u.Incarnation {
+				t.Fatalf("%s of incarnation %d published %s of %d, want %s", step.u.Status, step.u.Incarnation,
+					e.Type, e.Member.Incarnation, step.event)
+			}
+		case <-time.After(TEST_TIMEOUT):
+			t.Fatalf("no event for %s of incarnation %d", step.u.Status, step.u.Incarnation)
+		}
+		if step.u.Meta != nil {
+			if m, _ := memberRecord(n, peer); m.Meta["zone"] != step.u.Meta["zone"] {
+				t.Errorf("zone %q after incarnation %d, want %q", m.Meta["zone"], step.u.Incarnation, step.u.Meta["zone"])
+			}
+		}
+	}
+}
+
+/*
+ * A removal is remembered for removalWindow, after which the host is forgotten
+ */
+func TestRemovalsExpire(t *testing.T) {
+	n := newTestNode(t, transport.NewNetwork(), 1, 3, nil)
+	t.Cleanup(n.Stop)
+	peer := testID(2)
+	n.applyUpdate(update{peer, ALIVE, 10, nil})
+	n.applyUpdate(update{peer, "Failed", 10, nil})
+
+	n.expireRemovals()
+	n.applyUpdate(update{peer, ALIVE, 10, nil})
+	if hasMember(n, peer) {
+		t.Fatal("stale Alive of the removed incarnation was accepted within the removal window")
+	}
+
+	n.mutex.Lock()
+	n.removedMembers[peer] = removal{10, time.Now().Add(-n.conf.SuspectTimeout.Duration - time.Minute)}
+	n.mutex.Unlock()
+	n.expireRemovals()
+	n.mutex.Lock()
+	removed := len(n.removedMembers)
+	n.mutex.Unlock()
+	if removed != 0 {
+		t.Errorf("%d removals kept after the removal window, want 0", removed)
+	}
+}
+
+func TestRefuteSuspicionOfSelf(t *testing.T) {
+	n := newTestNode(t, transport.NewNetwork(), 1, 3, nil)
+	t.Cleanup(n.Stop)
+	before := n.Self().Incarnation
+
+	n.applyUpdate(update{n.ID(), SUSPECT, before, nil})
+	after := n.Self().Incarnation
+	if after <= before {
+		t.Fatalf("incarnation %d after being suspected, want above %d", after, before)
+	}
+	if self, _ := memberRecord(n, n.ID()); self.State != ALIVE || self.Incarnation != after {
+		t.Errorf("own record is %s with incarnation %d, want %s with %d", self.State, self.Incarnation, ALIVE, after)
+	}
+
+	// A suspicion of an incarnation already refuted changes nothing
+	n.applyUpdate(update{n.ID(), SUSPECT, before, nil})
+	if again := n.Self().Incarnation; again != after {
+		t.Errorf("incarnation %d after a stale suspicion, want %d", again, after)
+	}
+}
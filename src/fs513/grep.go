@@ -0,0 +1,85 @@
+package fs513
+
+import (
+	"config"
+	"errors"
+	"grepserver"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+ * Grep requests go to the grep_port of every member over a stream, sealed with the cluster key like any
+ * other request. The member checks the arguments itself before running grep, see grepserver
+ */
+type grepQuery struct {
+	Args []string
+}
+
+type grepAnswer struct {
+	Result string // Matches in the log of the member, labelled with its ID
+	Error  string // Why the request was refused, empty on success
+}
+
+/*
+ * Answer the grep requests sent to the grep_port
+ */
+func (n *Node) listenToGrep(listener net.Listener) {
+	newQuery := func() interface{} { return &grepQuery{} }
+	n.acceptState(listener, "listen grep", newQuery, func(v interface{}) interface{} {
+		result, err := grepserver.Search(v.(*grepQuery).Args, n.conf.LogPath, n.currHost)
+		if err != nil {
+			return grepAnswer{Error: err.Error()}
+		}
+		return grepAnswer{Result: result}
+	})
+}
+
+/*
+ * Grep runs grep with args on the logs of every member and returns the result of each member, in the
+ * order they arrive. args may only hold a pattern and the flags of grepserver.GREP_FLAGS. Members which
+ * could not be reached are reported in the results and in the error
+ */
+func (n *Node) Grep(args []string) ([]string, error) {
+	if _, err := grepserver.CommandLine(args); err != nil {
+		return nil, err
+	}
+	members := n.Members()
+	start := time.Now()
+	type reply struct {
+		result string
+		failed bool
+	}
+	replies := make(chan reply, len(members))
+	for _, m := range members {
+		go func(host string) {
+			answer := grepAnswer{}
+			err := n.request(host, config.GREP_OFFSET, grepQuery{args}, &answer)
+			if err == nil && answer.Error != "" {
+				err = errors.New(host + ": " + answer.Error)
+			}
+			if err != nil {
+				replies <- reply{err.Error(), true}
+				return
+			}
+			replies <- reply{answer.Result, false}
+		}(m.Host)
+	}
+
+	results := make([]string, 0, len(members))
+	failed := 0
+	for range members {
+		r := <-replies
+		results = append(results, r.result)
+		if r.failed {
+			failed++
+		}
+	}
+	var err error
+	if failed > 0 {
+		err = errors.New(strconv.Itoa(failed) + " members did not answer")
+	}
+	n.metrics.operation(OP_GREP, time.Since(start), err)
+	return results, err
+}
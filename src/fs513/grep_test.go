@@ -0,0 +1,46 @@
+package fs513
+
+import (
+	"config"
+	"strings"
+	"testing"
+	"transport"
+)
+
+func TestGrepSearchesEveryMember(t *testing.T) {
+	network := transport.NewNetwork()
+	nodes := startCluster(t, network, 3, nil)
+	results, err := nodes[1].Grep([]string{"-i", "GROUP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("%d results, want one per member", len(results))
+	}
+	for _, n := range nodes {
+		found := false
+		for _, result := range results {
+			found = found || strings.HasPrefix(result, "Results from host ["+n.ID()+"]")
+		}
+		if !found {
+			t.Errorf("no match from %s in %q", n.ID(), results)
+		}
+	}
+
+	if _, err := nodes[1].Grep([]string{"-r", "x", "/etc"}); err == nil {
+		t.Error("grep of another file was sent")
+	}
+	answer := grepAnswer{}
+	if err := nodes[1].request(nodes[0].ID(), config.GREP_OFFSET, grepQuery{[]string{"x", "/etc/passwd"}}, &answer); err != nil || answer.Error == "" {
+		t.Errorf("grep of another file answered with %q, %v", answer.Result, err)
+	}
+
+	// A node without the cluster key gets no answer
+	outsider := newTestNode(t, network, 5, 3, func(conf *config.Config) {
+		conf.ClusterKeys = []string{"another-cluster-key-entirely"}
+	})
+	t.Cleanup(outsider.Stop)
+	if err := outsider.request(nodes[0].ID(), config.GREP_OFFSET, grepQuery{[]string{"x"}}, &answer); err == nil {
+		t.Error("grep without the cluster key was answered")
+	}
+}
@@ -0,0 +1,101 @@
+package fs513
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+ * Graceful leave. Before announcing its Leave a node copies every replica it holds to a new owner, the
+ * first member after it on the ring which does not hold the file yet, and has the leader swap it for the
+ * new owner in the file list. Only once the leader confirmed the update does the node leave, so no file
+ * drops below its number of copies.
+ */
+type handoffRequest struct {
+	Host  string
+	Files map[string]string // File -> member which received the replica of Host
+}
+
+/*
+ * Push every local replica to its new owner and wait for the leader to update the file list
+ */
+func (n *Node) handOff() error {
+	n.mutex.Lock()
+	moves := make(map[string]string)
+	for name, meta := range n.fs513_list {
+		if !contains(meta.Replicas, n.currHost) {
+			continue
+		}
+		if target := n.newOwner(meta.Replicas); target != "" {
+			moves[name] = target
+		} else {
+			n.errlog.Println("No member left to take over " + name + ", leaving with fewer copies")
+		}
+	}
+	n.mutex.Unlock()
+
+	if len(moves) == 0 {
+		return nil
+	}
+	for name, target := range moves {
+		if err := n.pushAllVersions(name, target); err != nil {
+			return fmt.Errorf("handoff of %s to %s failed: %v", name, target, err)
+		}
+		n.infolog.Println("Handed off " + name + " to " + target)
+	}
+
+	req := handoffRequest{n.currHost, moves}
+	if _, err := n.gatewayRequest(n.getLeader(), gatewayRequest{Handoff: &req}); err != nil {
+		return fmt.Errorf("handoff not confirmed by the leader: %v", err)
+	}
+	n.infolog.Println("Handed off " + strconv.Itoa(len(moves)) + " files")
+	return nil
+}
+
+/*
+ * First member after the current host on the ring which is not in holders. Must be called with mutex held
+ */
+func (n *Node) newOwner(holders []string) string {
+	ix := n.getIx()
+	for i := 1; i < len(n.membershipGroup); i++ {
+		host := n.membershipGroup[(ix+i)%len(n.membershipGroup)].Host
+		if !contains(holders, host) {
+			return host
+		}
+	}
+	return ""
+}
+
+/*
+ * Run by the leader: replace the leaving host by the new owners in the file list and broadcast it
+ */
+func (n *Node) handleHandoff(req *handoffRequest) gatewayResponse {
+	if !n.isLeader() {
+		return gatewayResponse{Redirect: n.getLeader()}
+	}
+
+	n.mutex.Lock()
+	for name, target := range req.Files {
+		meta, ok := n.fs513_list[name]
+		if !ok {
+			continue
+		}
+		// Swap in place, the position of a replica in the list is kept
+		newIps := make([]string, 0)
+		for _, ip := range meta.Replicas {
+			if ip == req.Host {
+				ip = target
+			}
+			if !contains(newIps, ip) {
+				newIps = append(newIps, ip)
+			}
+		}
+		meta.Replicas = newIps
+		n.fs513_list[name] = meta
+	}
+	n.mutex.Unlock()
+
+	n.infolog.Println("Handoff of " + strconv.Itoa(len(req.Files)) + " files from " + req.Host + " applied")
+	n.broadcastFileList()
+	return gatewayResponse{}
+}
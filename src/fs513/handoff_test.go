@@ -0,0 +1,45 @@
+package fs513
+
+import (
+	"testing"
+	"transport"
+)
+
+/*
+ * Every replica of a member leaving is copied to a new owner and swapped in by the leader before the Leave
+ * returns, so no file drops below its replication
+ */
+func TestLeaveHandsOffReplicas(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 4, nil)
+	names := []string{"a.txt", "b.txt", "c.txt", "d.txt"}
+	for _, name := range names {
+		if err := nodes[1].Put(writeTestFile(t, "content of "+name), name, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leaving := nodes[2]
+	for _, name := range names {
+		waitFor(t, leaving.ID()+" to list 3 replicas of "+name, func() bool {
+			return len(leaving.Locate(name)) == 3
+		})
+	}
+
+	if err := leaving.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		replicas := nodes[0].Locate(name)
+		if len(replicas) != 3 || contains(replicas, leaving.ID()) {
+			t.Errorf("replicas of %s after the Leave are %v, want 3 without %s", name, replicas, leaving.ID())
+			continue
+		}
+		for _, n := range []*Node{nodes[0], nodes[1], nodes[3]} {
+			if contains(replicas, n.ID()) && len(n.localVersions(name)) == 0 {
+				t.Errorf("%s is listed for %s but holds no copy", n.ID(), name)
+			}
+		}
+		if got := fetchContent(t, nodes[3], name); got != "content of "+name {
+			t.Errorf("fetched %q for %s after the handoff, want %q", got, name, "content of "+name)
+		}
+	}
+}
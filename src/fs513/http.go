@@ -0,0 +1,32 @@
+package fs513
+
+import (
+	"net"
+	"net/http"
+)
+
+/*
+ * HTTP endpoints served on the http_port of every node:
+ *
+ *   GET /metrics   the metrics of the node in Prometheus text format
+ */
+func (n *Node) serveHTTP(listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", n.handleMetrics)
+
+	server := &http.Server{Handler: mux}
+	if err := server.Serve(listener); err != nil && !n.stopping() {
+		n.errlog.Println("http:", err)
+	}
+}
+
+func (n *Node) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", PROMETHEUS_CONTENT_TYPE)
+	if err := n.Metrics().WritePrometheus(w); err != nil {
+		n.errlog.Println("http: writing metrics:", err)
+	}
+}
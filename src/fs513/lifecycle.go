@@ -0,0 +1,149 @@
+package fs513
+
+import (
+	"errors"
+)
+
+/*
+ * Lifecycle of a node in the group. A node starts Left and can go through Joining, Active, Leaving and
+ * Left any number of times within one process. Each rejoin uses a fresh incarnation, so the group takes it
+ * for a new member rather than for a stale record of the old one. The introducer joins like any other
+ * node and only forms a new group when no member it knows answers, so a restarted introducer rejoins the
+ * running group and its elected leader instead of splitting it.
+ */
+type NodeState string
+
+const (
+	STATE_JOINING NodeState = "Joining" // Join handshake with the gateway in progress
+	STATE_ACTIVE  NodeState = "Active"  // Member of the group
+	STATE_LEAVING NodeState = "Leaving" // Announcing the Leave to the group
+	STATE_LEFT    NodeState = "Left"    // Not part of a group, msgs from members are ignored
+)
+
+/*
+ * State returns where the node is in its lifecycle
+ */
+func (n *Node) State() NodeState {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.state
+}
+
+/*
+ * Move from one of the states in from to the state to. Must be called with mutex held
+ */
+func (n *Node) transition(to NodeState, from ...NodeState) error {
+	for _, s := range from {
+		if n.state == s {
+			n.infolog.Println("State " + string(n.state) + " -> " + string(to))
+			n.state = to
+			return nil
+		}
+	}
+	return errors.New("cannot go from " + string(n.state) + " to " + string(to))
+}
+
+/*
+ * Join runs the join handshake with the introducer, or with another member it knows if the introducer
+ * does not answer. Returns once the node is part of the group, or with the reason it was rejected
+ */
+func (n *Node) Join() error {
+	n.mutex.Lock()
+	err := n.transition(STATE_JOINING, STATE_LEFT)
+	n.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = n.gatewayConnect()
+	if err == errNoGroup && n.currHost == n.conf.Introducer {
+		n.formGroup()
+		err = nil
+	}
+
+	n.mutex.Lock()
+	if err != nil {
+		n.transition(STATE_LEFT, STATE_JOINING)
+	} else {
+		n.transition(STATE_ACTIVE, STATE_JOINING)
+	}
+	n.mutex.Unlock()
+	return err
+}
+
+/*
+ * Start a new group with this node as its only member and leader
+ */
+func (n *Node) formGroup() {
+	n.electionMutex.Lock()
+	n.leader = n.currHost
+	n.electionMutex.Unlock()
+	n.infolog.Println("No member answered, formed a new group")
+}
+
+/*
+ * Leave hands off the local replicas, announces the departure of this node to the group and resets it to
+ * a node which never joined. The node keeps running and can Join again. If the handoff fails the node
+ * stays Active and the error is returned
+ */
+func (n *Node) Leave() error {
+	n.mutex.Lock()
+	err := n.transition(STATE_LEAVING, STATE_ACTIVE)
+	n.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := n.handOff(); err != nil {
+		n.mutex.Lock()
+		n.transition(STATE_ACTIVE, STATE_LEAVING)
+		n.mutex.Unlock()
+		return err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.exitGroup()
+	n.resetGroup()
+	n.transition(STATE_LEFT, STATE_LEAVING)
+	return nil
+}
+
+/*
+ * Forget the group: the membership list shrinks to this node with a fresh incarnation, suspicions,
+ * queued gossip and the file list are dropped. Must be called with mutex held
+ */
+func (n *Node) resetGroup() {
+	for host := range n.suspectTimers {
+		n.stopSuspicion(host)
+	}
+	self := n.membershipGroup[n.getIx()]
+	n.publish(EVENT_LEAVE, self)
+	n.knownMembers = make([]string, 0)
+	for _, element := range n.membershipGroup {
+		if element.Host != n.currHost {
+			n.knownMembers = append(n.knownMembers, element.Host)
+		}
+	}
+
+	n.membershipGroup = make([]Member, 0)
+	n.removedMembers = make(map[string]removal)
+	n.probeOrder = make([]string, 0)
+	n.initMG()
+
+	n.gossipMutex.Lock()
+	n.gossipQueue = make([]*queuedUpdate, 0)
+	n.gossipMutex.Unlock()
+
+	n.fs513_list = make(map[string]fileMeta)
+	n.reservations = make(map[string]versionGrant)
+	n.fileListVersion = 0
+	if n.conf.CleanStorage {
+		// The replicas were handed off, drop the local copies
+		n.removeReplicas()
+	}
+
+	n.electionMutex.Lock()
+	n.leader = n.conf.Introducer
+	n.electionMutex.Unlock()
+}
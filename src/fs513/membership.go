@@ -0,0 +1,303 @@
+package fs513
+
+import (
+	"config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"transport"
+)
+
+// Message structure
+type message struct {
+	From          string // Member sending the msg, set by sendToHosts. Host may be another member
+	Host          string
+	Type          msgType
+	Incarnation   uint64 // Incarnation of Host a Failed or Leave msg refers to
+	FS513Name 	  string
+	Seq           uint64 // Probe sequence number matching an ACK to its SYN or PingReq, sent as the request ID
+	Target        string // Member an indirect probe is asked to ping
+	Updates       []update // Membership updates piggybacked for gossip
+}
+
+// Member structure
+type Member struct {
+	Host        string            `json:"host"`
+	Incarnation uint64            `json:"incarnation"` // Raised by the member itself on every join and refutation
+	State       string            `json:"state"`       // ALIVE or SUSPECT
+	Meta        map[string]string `json:"meta,omitempty"` // Metadata the member announced when joining, from its meta setting
+}
+
+func (m Member) copy() Member {
+	if m.Meta != nil {
+		meta := make(map[string]string, len(m.Meta))
+		for k, v := range m.Meta {
+			meta[k] = v
+		}
+		m.Meta = meta
+	}
+	return m
+}
+
+/*
+ * Listen to messages on UDP port from other nodes and take appropriate action. Possible message types are
+ * SYN, ACK, PingReq, Failed and Leave. Any msg may carry gossiped membership updates
+ */
+func (n *Node) listenToMessages(conn transport.PacketConn) {
+
+	for {
+		data, from, err := conn.ReadPacket()
+		if err != nil {
+			if !n.stopping() {
+				n.errlog.Println(err)
+			}
+			return
+		}
+		n.metrics.bytesIn("msg", len(data))
+		f, err := n.auth.open(data)
+		if err != nil {
+			n.errlog.Println("listenmessages:Rejected msg from "+from+":", err)
+			continue
+		}
+		pkt := message{}
+		if err := unmarshal(f.Type, f.RequestID, f.Payload, &pkt); err != nil {
+			n.auth.reject(err)
+			n.errlog.Println("listenmessages:Rejected ["+f.Type.String()+"] msg from "+from+":", err)
+			continue
+		}
+		n.metrics.msgReceived(f.Type)
+		go n.processMsg(pkt)
+	}
+}
+
+func (n *Node) processMsg(pkt message){
+		if n.State() == STATE_LEFT {
+			return
+		}
+		n.applyUpdates(pkt.Updates)
+		n.metrics.heardFrom(pkt.From)
+		switch pkt.Type {
+		case MSG_SYN:
+			n.respondAck(pkt)
+		case MSG_ACK:
+			n.ackReceived(pkt)
+		case MSG_PING_REQ:
+			n.probeFor(pkt)
+		case MSG_FAILED, MSG_LEAVE:
+			n.infolog.Println("Received [" + pkt.Type.String() + "] Msg from " + pkt.Host + " TS - " + time.Now().Format(time.StampMicro))
+			n.applyUpdate(update{pkt.Host, pkt.Type.String(), pkt.Incarnation, nil})
+		case MSG_DEL_FILE:   // Received only by Gateway
+			if !n.isLeader() {
+				n.sendToHosts(pkt, []string{n.getLeader()})
+				return
+			}
+			n.dropFile(pkt.FS513Name)
+		case MSG_RM_FILE:   // Received by node where file is located
+			n.removeFileFromFS(pkt.FS513Name)
+		case MSG_BAD_REPLICA:   // Received only by Gateway
+			if !n.isLeader() {
+				n.sendToHosts(pkt, []string{n.getLeader()})
+				return
+			}
+			n.replaceBadReplica(pkt.FS513Name, pkt.Host)
+		case MSG_REPLICATE_FILE:
+			// Push the local replica to the requesting host
+			if err := n.pushAllVersions(pkt.FS513Name, pkt.Host); err != nil {
+				n.errlog.Println("Not able to copy "+pkt.FS513Name+" to "+pkt.Host+":", err)
+			}
+		case MSG_ELECTION, MSG_ANSWER, MSG_COORDINATOR:
+			n.processElectionMsg(pkt)
+		}
+}
+/*
+ * Initailize the ML with current host
+ */
+func (n *Node) initMG() {
+	n.incarnation = n.newIncarnation()
+	node := Member{n.currHost, n.incarnation, ALIVE, n.conf.Meta}
+	n.membershipGroup = append(n.membershipGroup, node)
+}
+
+
+/*
+ * The function which removes the node from the Membershiplist and updates the list.
+ * Go library gives the flexiblity of moving the elements in the static array very elegantly by append and Array slice operators
+ */
+func (n *Node) updateMG(Ix int, u update) {
+	if u.Incarnation >= n.membershipGroup[Ix].Incarnation {
+		n.removedMembers[u.Host] = removal{u.Incarnation, time.Now()}
+		if u.Status == "Failed" {
+			n.metrics.memberFailed(u.Host)
+		} else {
+			n.metrics.memberLeft(u.Host)
+		}
+		n.publish(EventType(u.Status), n.membershipGroup[Ix])
+		n.membershipGroup = append(n.membershipGroup[:Ix], n.membershipGroup[Ix+1:]...)
+		n.infolog.Println("Processed ["+u.Status+"] Msg from "+u.Host+" TS - ", time.Now().Format(time.StampMicro))
+	} else {
+		stale := "Incarnation of msg [" + strconv.FormatUint(u.Incarnation, 10) + "] older than my record [" + strconv.FormatUint(n.membershipGroup[Ix].Incarnation, 10) + "]"
+		n.infolog.Println(stale)
+	}
+}
+
+/*
+ * Get index of current host
+ */
+func (n *Node) getIx() int {
+	for i, element := range n.membershipGroup {
+		if n.currHost == element.Host {
+			return i
+		}
+	}
+	return -1
+}
+
+func (n *Node) getIdxOfHost(host string) int {
+	for i, element := range n.membershipGroup {
+		if host == element.Host {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+ * This function is for any node which wants to leave the group. Message is formed and sent to three predecessors,
+ * which gossip it to the rest of the group
+ */
+func (n *Node) exitGroup() {
+	msg := message{Host: n.currHost, Type: MSG_LEAVE, Incarnation: n.incarnation}
+
+	var targetConnections = make([]string, 3)
+	for i := 1; i < 4; i++ {
+		var targetHostIndex = (n.getIx() - i) % len(n.membershipGroup)
+		if targetHostIndex < 0 {
+			targetHostIndex = len(n.membershipGroup) + targetHostIndex
+		}
+		targetConnections[i-1] = n.membershipGroup[targetHostIndex].Host
+	}
+
+	n.sendToHosts(msg, targetConnections)
+}
+
+/*
+ * Send given message to the target nodes
+ */
+func (n *Node) sendToHosts(msg message, targetConnections []string) {
+	msg.From = n.currHost
+	t, payload, err := marshal(msg)
+	if err != nil {
+		n.errlog.Println("sendToHosts: problem during encoding:", err)
+		return
+	}
+
+	for _, targetHost := range targetConnections {
+		if msg.Type == MSG_LEAVE || msg.Type == MSG_FAILED {
+			n.infolog.Print("Propagating ")
+			n.infolog.Print(msg)
+			n.infolog.Print(" to :")
+			n.infolog.Println(targetHost)
+		}
+
+		// Sealed per target, so a copy captured on the way to one member cannot be replayed to another
+		data, err := n.auth.seal(t, msg.Seq, payload)
+		if err != nil {
+			n.errlog.Println("sendToHosts: problem during encoding:", err)
+			return
+		}
+		if err := n.trans.SendTo(config.PortAddr(targetHost, config.MSG_OFFSET), data); err != nil {
+			n.errlog.Println("sendToHosts: problem while writing to "+targetHost+":", err)
+			continue
+		}
+		n.metrics.msgSent(t)
+		n.metrics.bytesOut("msg", len(data))
+	}
+}
+
+/*
+ * Add a joining member or replace the record of a member which rejoined with a higher incarnation.
+ * Returns false if the member is already known with this incarnation or was removed with it. Must be
+ * called with mutex held.
+ */
+func (n *Node) mergeMember(m Member) bool {
+	if removed, ok := n.removedMembers[m.Host]; ok && removed.Incarnation >= m.Incarnation {
+		return false
+	}
+	for i, element := range n.membershipGroup {
+		if m.Host == element.Host {
+			if m.Incarnation <= element.Incarnation {
+				return false
+			}
+			delete(n.removedMembers, m.Host)
+			n.metrics.memberJoined(m.Host)
+			n.membershipGroup[i] = m
+			n.stopSuspicion(m.Host)
+			n.publish(EVENT_JOIN, m)
+			return true
+		}
+	}
+	delete(n.removedMembers, m.Host)
+	n.metrics.memberJoined(m.Host)
+	n.membershipGroup = append(n.membershipGroup, m)
+	n.publish(EVENT_JOIN, m)
+	return true
+}
+
+/*
+ * Incarnations are only ever compared with earlier incarnations of the same host. A new one is above both
+ * the local clock in nanoseconds and the last one saved in the storage directory, so a restarted node is
+ * newer than its previous run even if the clock went back or refutations raised it past the clock.
+ */
+func (n *Node) newIncarnation() uint64 {
+	incarnation := uint64(time.Now().UnixNano())
+	if data, err := ioutil.ReadFile(filepath.Join(n.storageDir, INCARNATION_FILE)); err == nil {
+		last, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err == nil && last >= incarnation {
+			incarnation = last + 1
+		}
+	}
+	n.saveIncarnation(incarnation)
+	return incarnation
+}
+
+func (n *Node) saveIncarnation(incarnation uint64) {
+	os.MkdirAll(n.storageDir, os.ModePerm)
+	data := []byte(strconv.FormatUint(incarnation, 10) + "\n")
+	if err := ioutil.WriteFile(filepath.Join(n.storageDir, INCARNATION_FILE), data, 0644); err != nil {
+		n.errlog.Println("Not able to save incarnation "+strconv.FormatUint(incarnation, 10)+":", err)
+	}
+}
+
+/*
+ * Incarnation a failed or left member was removed with. Stale ALIVE updates of that incarnation only
+ * travel while the suspicion and the gossip about the removal last, see removalWindow
+ */
+type removal struct {
+	Incarnation uint64
+	At          time.Time
+}
+
+/*
+ * How long a removal is remembered: the suspect_timeout plus the time the removal takes to be
+ * retransmitted, at least once per probe_interval
+ */
+func (n *Node) removalWindow() time.Duration {
+	return n.conf.SuspectTimeout.Duration + time.Duration(n.retransmitLimit())*n.conf.ProbeInterval.Duration
+}
+
+/*
+ * Forget the removals older than removalWindow, so the map does not grow with every host that ever left
+ */
+func (n *Node) expireRemovals() {
+	window := n.removalWindow()
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for host, r := range n.removedMembers {
+		if time.Since(r.At) > window {
+			delete(n.removedMembers, host)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package fs513
+
+import (
+	"config"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+ * Metrics of the failure detector, the transport and file transfers. Counters only ever grow while the
+ * node runs, so rates are taken by comparing two snapshots.
+ *
+ * False positives are members declared Failed which are later heard from without having rejoined, i.e.
+ * with the same run of the process. A member which restarts and rejoins with a fresh incarnation is a
+ * true failure.
+ */
+const MAX_SIZE_SAMPLES = 1000 // Changes of the membership size kept, the oldest are dropped first
+
+// File operations timed by the metrics
+const (
+	OP_PUT    = "put"
+	OP_GET    = "get"
+	OP_DELETE = "delete"
+	OP_GREP   = "grep"
+)
+
+var (
+	RTT_BOUNDS       = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	DETECTION_BOUNDS = []float64{0.5, 1, 2, 3, 5, 10, 20, 30, 60}
+	OP_BOUNDS        = []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+)
+
+/*
+ * Metrics is a snapshot of the counters of a node
+ */
+type Metrics struct {
+	Time            time.Time            `json:"time"`
+	Leader          bool                 `json:"leader"`           // Whether this node is the gateway
+	Members         int                  `json:"members"`          // Current size of the membership list
+	LocalReplicas   int                  `json:"local_replicas"`   // Files this node holds a replica of
+	UnderReplicated int                  `json:"under_replicated"` // Files short of replicas, only counted by the gateway
+	AckRTTByMember  map[string]Histogram `json:"ack_rtt_by_member"`
+	Ops             map[string]OpStats   `json:"ops"` // Put, get, delete and grep issued on this node
+
+	MsgsSent       map[string]uint64 `json:"msgs_sent"`       // Frames sent by msg type
+	MsgsReceived   map[string]uint64 `json:"msgs_received"`   // Authenticated frames received by msg type
+	BytesSent      map[string]uint64 `json:"bytes_sent"`      // Bytes written by port: msg, mg, fl, grep, http, admin, control or transfer
+	BytesReceived  map[string]uint64 `json:"bytes_received"`  // Bytes read by port
+	Rejected       uint64            `json:"rejected"`        // Frames which failed authentication or decoding
+	AckRTT         Histogram         `json:"ack_rtt"`         // Round trip of a SYN to its direct ACK
+	DetectionTime  Histogram         `json:"detection_time"`  // From the last ACK of a member to declaring it Failed
+	FalsePositives uint64            `json:"false_positives"` // Members declared Failed and heard from again
+	MembershipSize []SizeSample      `json:"membership_size"` // Size of the list each time it changed
+	Transfers      TransferStats     `json:"transfers"`
+}
+
+type OpStats struct {
+	Latency Histogram `json:"latency"`
+	Errors  uint64    `json:"errors"`
+}
+
+/*
+ * Histogram of durations in seconds. Buckets[i] counts the observations up to Bounds[i] which do not fit
+ * an earlier bucket, the last bucket counts everything above the last bound
+ */
+type Histogram struct {
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+	Min     float64   `json:"min"`
+	Max     float64   `json:"max"`
+	Bounds  []float64 `json:"bounds"`
+	Buckets []uint64  `json:"buckets"`
+}
+
+type SizeSample struct {
+	Time time.Time `json:"time"`
+	Size int       `json:"size"`
+}
+
+/*
+ * File replicas copied to other nodes
+ */
+type TransferStats struct {
+	Count          uint64  `json:"count"`
+	Failed         uint64  `json:"failed"`
+	Bytes          uint64  `json:"bytes"`
+	Seconds        float64 `json:"seconds"`          // Time spent in successful transfers
+	BytesPerSecond float64 `json:"bytes_per_second"` // Average throughput of successful transfers
+	BadReplicas    uint64  `json:"bad_replicas"`     // Local copies found not to match their checksum
+}
+
+type metrics struct {
+	mutex          sync.Mutex
+	sent           map[string]uint64
+	received       map[string]uint64
+	bytesSent      map[string]uint64
+	bytesReceived  map[string]uint64
+	ackRTT         Histogram
+	memberRTT      map[string]*Histogram // Member -> round trips of its direct ACKs, dropped when it is removed
+	ops            map[string]*OpStats
+	detection      Histogram
+	falsePositives uint64
+	lastAck        map[string]time.Time // Member -> time of its last ACK
+	failed         map[string]bool      // Members declared Failed which did not rejoin since
+	sizes          []SizeSample
+	transfers      TransferStats
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		sent:          make(map[string]uint64),
+		received:      make(map[string]uint64),
+		bytesSent:     make(map[string]uint64),
+		bytesReceived: make(map[string]uint64),
+		ackRTT:        newHistogram(RTT_BOUNDS),
+		memberRTT:     make(map[string]*Histogram),
+		ops:           make(map[string]*OpStats),
+		detection:     newHistogram(DETECTION_BOUNDS),
+		lastAck:       make(map[string]time.Time),
+		failed:        make(map[string]bool),
+		sizes:         make([]SizeSample, 0),
+	}
+}
+
+func newHistogram(bounds []float64) Histogram {
+	return Histogram{Bounds: bounds, Buckets: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	if h.Count == 0 || v < h.Min {
+		h.Min = v
+	}
+	if v > h.Max {
+		h.Max = v
+	}
+	h.Count++
+	h.Sum += v
+	i := 0
+	for i < len(h.Bounds) && v > h.Bounds[i] {
+		i++
+	}
+	h.Buckets[i]++
+}
+
+func (h Histogram) copy() Histogram {
+	h.Buckets = append([]uint64(nil), h.Buckets...)
+	return h
+}
+
+/*
+ * Name of the port at the given offset, used as label of the byte counters
+ */
+func portName(offset int) string {
+	switch offset {
+	case config.MSG_OFFSET:
+		return "msg"
+	case config.MG_OFFSET:
+		return "mg"
+	case config.FL_OFFSET:
+		return "fl"
+	case config.GREP_OFFSET:
+		return "grep"
+	case config.HTTP_OFFSET:
+		return "http"
+	case config.ADMIN_OFFSET:
+		return "admin"
+	case config.TRANSFER_OFFSET:
+		return "transfer"
+	}
+	return "unknown"
+}
+
+func (m *metrics) msgSent(t msgType) {
+	m.mutex.Lock()
+	m.sent[t.String()]++
+	m.mutex.Unlock()
+}
+
+func (m *metrics) msgReceived(t msgType) {
+	m.mutex.Lock()
+	m.received[t.String()]++
+	m.mutex.Unlock()
+}
+
+func (m *metrics) bytesIn(port string, size int) {
+	m.mutex.Lock()
+	m.bytesReceived[port] += uint64(size)
+	m.mutex.Unlock()
+}
+
+func (m *metrics) bytesOut(port string, size int) {
+	m.mutex.Lock()
+	m.bytesSent[port] += uint64(size)
+	m.mutex.Unlock()
+}
+
+/*
+ * An ACK from host arrived, rtt is zero for an ACK relayed by an indirect probe
+ */
+func (m *metrics) ackReceived(host string, rtt time.Duration) {
+	m.mutex.Lock()
+	m.lastAck[host] = time.Now()
+	if rtt > 0 {
+		m.ackRTT.observe(rtt)
+		h, ok := m.memberRTT[host]
+		if !ok {
+			hist := newHistogram(RTT_BOUNDS)
+			h = &hist
+			m.memberRTT[host] = h
+		}
+		h.observe(rtt)
+	}
+	m.mutex.Unlock()
+}
+
+func (m *metrics) memberFailed(host string) {
+	m.mutex.Lock()
+	if last, ok := m.lastAck[host]; ok {
+		m.detection.observe(time.Since(last))
+	}
+	delete(m.lastAck, host)
+	delete(m.memberRTT, host)
+	m.failed[host] = true
+	m.mutex.Unlock()
+}
+
+func (m *metrics) memberLeft(host string) {
+	m.mutex.Lock()
+	delete(m.lastAck, host)
+	delete(m.memberRTT, host)
+	m.mutex.Unlock()
+}
+
+/*
+ * The host joined again, a failure before is not a false positive anymore
+ */
+func (m *metrics) memberJoined(host string) {
+	m.mutex.Lock()
+	delete(m.failed, host)
+	m.mutex.Unlock()
+}
+
+/*
+ * A msg from host was processed. Counts a false positive if it was declared Failed and did not rejoin
+ */
+func (m *metrics) heardFrom(host string) {
+	m.mutex.Lock()
+	if m.failed[host] {
+		delete(m.failed, host)
+		m.falsePositives++
+	}
+	m.mutex.Unlock()
+}
+
+func (m *metrics) sampleSize(size int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.sizes) > 0 && m.sizes[len(m.sizes)-1].Size == size {
+		return
+	}
+	if len(m.sizes) == MAX_SIZE_SAMPLES {
+		m.sizes = m.sizes[1:]
+	}
+	m.sizes = append(m.sizes, SizeSample{time.Now(), size})
+}
+
+func (m *metrics) transfer(size int64, d time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err != nil {
+		m.transfers.Failed++
+		return
+	}
+	m.transfers.Count++
+	m.transfers.Bytes += uint64(size)
+	m.transfers.Seconds += d.Seconds()
+}
+
+func (m *metrics) badReplica() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.transfers.BadReplicas++
+}
+
+func (m *metrics) operation(op string, d time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats, ok := m.ops[op]
+	if !ok {
+		stats = &OpStats{Latency: newHistogram(OP_BOUNDS)}
+		m.ops[op] = stats
+	}
+	stats.Latency.observe(d)
+	if err != nil {
+		stats.Errors++
+	}
+}
+
+func (m *metrics) snapshot() Metrics {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := Metrics{
+		Time:           time.Now(),
+		MsgsSent:       copyCounts(m.sent),
+		MsgsReceived:   copyCounts(m.received),
+		BytesSent:      copyCounts(m.bytesSent),
+		BytesReceived:  copyCounts(m.bytesReceived),
+		AckRTT:         m.ackRTT.copy(),
+		DetectionTime:  m.detection.copy(),
+		FalsePositives: m.falsePositives,
+		MembershipSize: append([]SizeSample(nil), m.sizes...),
+		Transfers:      m.transfers,
+	}
+	s.AckRTTByMember = make(map[string]Histogram, len(m.memberRTT))
+	for host, h := range m.memberRTT {
+		s.AckRTTByMember[host] = h.copy()
+	}
+	s.Ops = make(map[string]OpStats, len(m.ops))
+	for op, stats := range m.ops {
+		s.Ops[op] = OpStats{stats.Latency.copy(), stats.Errors}
+	}
+	if s.Transfers.Seconds > 0 {
+		s.Transfers.BytesPerSecond = float64(s.Transfers.Bytes) / s.Transfers.Seconds
+	}
+	return s
+}
+
+func copyCounts(counts map[string]uint64) map[string]uint64 {
+	c := make(map[string]uint64, len(counts))
+	for k, v := range counts {
+		c[k] = v
+	}
+	return c
+}
+
+/*
+ * Metrics returns a snapshot of the metrics of the node
+ */
+func (n *Node) Metrics() Metrics {
+	s := n.metrics.snapshot()
+	s.Rejected = n.auth.rejectedCount()
+	s.Leader = n.isLeader()
+	n.mutex.Lock()
+	s.Members = len(n.membershipGroup)
+	s.LocalReplicas = len(n.getLocalFiles())
+	if s.Leader {
+		s.UnderReplicated = len(n.underReplicated())
+	}
+	n.mutex.Unlock()
+	return s
+}
+
+/*
+ * countingConn adds the bytes read and written on a stream to the counters of its port
+ */
+type countingConn struct {
+	net.Conn
+	metrics *metrics
+	port    string
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	size, err := c.Conn.Read(b)
+	c.metrics.bytesIn(c.port, size)
+	return size, err
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	size, err := c.Conn.Write(b)
+	c.metrics.bytesOut(c.port, size)
+	return size, err
+}
+
+type countingListener struct {
+	net.Listener
+	metrics *metrics
+	port    string
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn, l.metrics, l.port}, nil
+}
+
+/*
+ * Open a stream to the port at the given offset of host, counting its bytes
+ */
+func (n *Node) dial(host string, offset int) (net.Conn, error) {
+	conn, err := n.trans.Dial(config.PortAddr(host, offset))
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn, n.metrics, portName(offset)}, nil
+}
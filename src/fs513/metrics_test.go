@@ -0,0 +1,53 @@
+package fs513
+
+import (
+	"testing"
+	"time"
+	"transport"
+)
+
+func TestMetricsCountProbes(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 3, nil)
+	n := nodes[0]
+	waitFor(t, "ACK round trips to be measured", func() bool {
+		return n.Metrics().AckRTT.Count > 0
+	})
+	m := n.Metrics()
+	if m.MsgsSent[MSG_SYN.String()] == 0 || m.MsgsReceived[MSG_ACK.String()] == 0 {
+		t.Errorf("%d SYN sent and %d ACK received, want both above 0", m.MsgsSent[MSG_SYN.String()],
+			m.MsgsReceived[MSG_ACK.String()])
+	}
+	if m.BytesSent["msg"] == 0 || m.BytesReceived["msg"] == 0 {
+		t.Errorf("%d bytes sent and %d received on the msg port, want both above 0", m.BytesSent["msg"],
+			m.BytesReceived["msg"])
+	}
+	if m.Members != 3 || !m.Leader {
+		t.Errorf("metrics of the introducer show %d members and leader %v, want 3 and true", m.Members, m.Leader)
+	}
+}
+
+/*
+ * A member declared failed which is heard from again was a false positive, unless it rejoined in between
+ */
+func TestFalsePositives(t *testing.T) {
+	m := newMetrics()
+	m.ackReceived("10.0.0.2:6000", time.Millisecond)
+	m.memberFailed("10.0.0.2:6000")
+	m.heardFrom("10.0.0.2:6000")
+	m.heardFrom("10.0.0.2:6000")
+
+	m.memberFailed("10.0.0.3:6000")
+	m.memberJoined("10.0.0.3:6000")
+	m.heardFrom("10.0.0.3:6000")
+
+	s := m.snapshot()
+	if s.FalsePositives != 1 {
+		t.Errorf("%d false positives, want 1", s.FalsePositives)
+	}
+	if s.DetectionTime.Count != 1 {
+		t.Errorf("%d detection times, want 1 for the member with an ACK", s.DetectionTime.Count)
+	}
+	if _, ok := s.AckRTTByMember["10.0.0.2:6000"]; ok {
+		t.Error("round trips of a failed member are still exported")
+	}
+}
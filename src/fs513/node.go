@@ -0,0 +1,407 @@
+package fs513
+
+import (
+	"config"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"transport"
+)
+
+/*
+ * Node is one member of an FS513 cluster. All of its state lives here, so several nodes can run side
+ * by side in one process, e.g. on a simulated transport.
+ */
+type Node struct {
+	conf     *config.Config
+	trans    transport.Transport
+	currHost string // ID of this node, advertise_addr:port
+
+	// Membership, guarded by mutex
+	mutex           *sync.Mutex
+	incarnation     uint64             // Incarnation of the current host
+	removedMembers  map[string]removal // Incarnation each failed or left host was removed with, and when
+	state           NodeState
+	membershipGroup []Member // Array holds the membership list
+	knownMembers    []string // Members of the last group this node left, tried when it joins again
+	subscribers     []*Subscription
+
+	// Files
+	fs513_list      map[string]fileMeta     // Replica locations and versions of every file, maintained by the leader
+	storageDir      string                  // Local directory holding the replicas of this node
+	fileListVersion uint64                  // Version of fs513_list, raised by the leader on every broadcast
+	reservations    map[string]versionGrant // Versions the leader handed out which are not in fs513_list yet
+
+	// Election
+	leader        string
+	electing      bool
+	electionMutex *sync.Mutex
+	answerCh      chan bool // Signalled when a higher node answers our Election msg
+	coordCh       chan bool // Signalled when a Coordinator msg arrives
+
+	// Failure detector
+	probeSeq      uint64
+	probeOrder    []string             // Members left to probe in the current round
+	pendingAcks   map[uint64]chan bool // Probes waiting for an ACK, by sequence number
+	ackMutex      *sync.Mutex
+	suspectTimers map[string]*time.Timer // Suspicion timeouts, guarded by mutex
+
+	// Gossip
+	gossipQueue []*queuedUpdate
+	gossipMutex *sync.Mutex
+
+	// For logging
+	logfile  *os.File
+	errlog   *log.Logger
+	infolog  *log.Logger
+	emptylog *log.Logger
+
+	auth       *authenticator // Seals and checks every msg and state transfer
+	requestSeq uint64         // Request ID of the last state transfer, updated atomically
+	metrics    *metrics
+
+	// Lifecycle
+	started  bool
+	done     chan struct{} // Closed by Stop
+	stopOnce sync.Once
+	closers  []io.Closer // Listeners opened by Start
+}
+
+/*
+ * New creates a node communicating over UDP and TCP
+ */
+func New(conf *config.Config) (*Node, error) {
+	return NewWithTransport(conf, transport.NewNetTransport(conf.AdvertiseAddr))
+}
+
+/*
+ * NewWithTransport creates a node communicating over trans
+ */
+func NewWithTransport(conf *config.Config, trans transport.Transport) (*Node, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	n := &Node{
+		conf:            conf,
+		trans:           trans,
+		currHost:        conf.ID(),
+		mutex:           &sync.Mutex{},
+		removedMembers:  make(map[string]removal),
+		membershipGroup: make([]Member, 0),
+		fs513_list:      make(map[string]fileMeta),
+		reservations:    make(map[string]versionGrant),
+		electionMutex:   &sync.Mutex{},
+		answerCh:        make(chan bool, 1),
+		coordCh:         make(chan bool, 1),
+		probeOrder:      make([]string, 0),
+		pendingAcks:     make(map[uint64]chan bool),
+		ackMutex:        &sync.Mutex{},
+		suspectTimers:   make(map[string]*time.Timer),
+		gossipQueue:     make([]*queuedUpdate, 0),
+		gossipMutex:     &sync.Mutex{},
+		done:            make(chan struct{}),
+		auth:            newAuthenticator(conf.ClusterKeys, conf.ReplayWindow.Duration),
+		metrics:         newMetrics(),
+	}
+	n.storageDir = conf.StorageDir(n.currHost)
+	if err := n.openLog(); err != nil {
+		return nil, err
+	}
+	n.initMG()
+	n.initLeader()
+	n.state = STATE_LEFT
+	return n, nil
+}
+
+func (n *Node) openLog() error {
+	absPath, _ := filepath.Abs(n.conf.LogPath)
+	logfile_exists := 1
+	if _, err := os.Stat(absPath); os.IsNotExist(err) {
+		logfile_exists = 0
+		os.MkdirAll(filepath.Dir(absPath), os.ModePerm)
+	}
+
+	logfile, err := os.OpenFile(absPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	n.logfile = logfile
+	n.errlog = log.New(logfile, "ERROR: ", log.Ldate|log.Lmicroseconds|log.Lshortfile)
+	n.infolog = log.New(logfile, "INFO: ", log.Ldate|log.Lmicroseconds)
+	n.emptylog = log.New(logfile, "\n----------------------------------------------------------------------------------------\n", log.Ldate|log.Ltime)
+
+	if logfile_exists == 1 {
+		n.emptylog.Println("")
+	}
+	return nil
+}
+
+/*
+ * Start prepares the storage directory, opens every listener and starts the protocol. Listeners are open
+ * when Start returns, so the node can join right away. The node stops once ctx is done or Stop is called.
+ */
+func (n *Node) Start(ctx context.Context) error {
+	if n.started {
+		return errors.New("fs513: node already started")
+	}
+	n.started = true
+
+	if err := n.initFS(); err != nil {
+		return err
+	}
+	if len(n.conf.ClusterKeys) == 0 {
+		n.errlog.Println("cluster_keys is not set, msgs are not authenticated")
+	}
+
+	msgConn, err := n.listenPacket(config.MSG_OFFSET)
+	if err != nil {
+		return err
+	}
+	mgListener, err := n.listenStream(config.MG_OFFSET)
+	if err != nil {
+		return err
+	}
+	flListener, err := n.listenStream(config.FL_OFFSET)
+	if err != nil {
+		return err
+	}
+	grepListener, err := n.listenStream(config.GREP_OFFSET)
+	if err != nil {
+		return err
+	}
+	httpListener, err := n.listenStream(config.HTTP_OFFSET)
+	if err != nil {
+		return err
+	}
+	transferListener, err := n.listenStream(config.TRANSFER_OFFSET)
+	if err != nil {
+		return err
+	}
+	if n.conf.AdminAddr != "" {
+		adminListener, err := n.listenAdmin()
+		if err != nil {
+			return err
+		}
+		go n.serveAdmin(adminListener, false)
+	}
+	if n.conf.ControlSocket != "" {
+		controlListener, err := n.listenControl()
+		if err != nil {
+			return err
+		}
+		go n.serveAdmin(controlListener, true)
+	}
+
+	go n.listenToMessages(msgConn)
+	go n.listenToGateway(mgListener)
+	go n.listenToGatewayFL(flListener)
+	go n.probeMembers()
+	go n.listenToGrep(grepListener)
+	go n.serveHTTP(httpListener)
+	go n.serveTransfers(transferListener)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			n.Stop()
+		case <-n.done:
+		}
+	}()
+	return nil
+}
+
+/*
+ * Open the datagram listener at the given port offset. On failure everything opened so far is closed
+ */
+func (n *Node) listenPacket(offset int) (transport.PacketConn, error) {
+	conn, err := n.trans.ListenPacket(n.conf.ListenOn(offset))
+	if err != nil {
+		n.Stop()
+		return nil, err
+	}
+	n.closers = append(n.closers, conn)
+	return conn, nil
+}
+
+/*
+ * Open the stream listener at the given port offset. On failure everything opened so far is closed
+ */
+func (n *Node) listenStream(offset int) (net.Listener, error) {
+	listener, err := n.trans.Listen(n.conf.ListenOn(offset))
+	if err != nil {
+		n.Stop()
+		return nil, err
+	}
+	n.closers = append(n.closers, listener)
+	return countingListener{listener, n.metrics, portName(offset)}, nil
+}
+
+/*
+ * Stop closes every listener and halts the protocol without announcing a Leave, use Leave first to
+ * leave the group gracefully. Safe to call more than once.
+ */
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.done)
+		for _, c := range n.closers {
+			c.Close()
+		}
+
+		n.mutex.Lock()
+		for host := range n.suspectTimers {
+			n.stopSuspicion(host)
+		}
+		n.closeSubscriptions()
+		n.mutex.Unlock()
+
+		n.infolog.Println("Node stopped")
+		n.logfile.Close()
+	})
+}
+
+func (n *Node) stopping() bool {
+	select {
+	case <-n.done:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+ * ID returns the identity of this node, advertise_addr:port
+ */
+func (n *Node) ID() string {
+	return n.currHost
+}
+
+func (n *Node) Incarnation() uint64 {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.incarnation
+}
+
+func (n *Node) Leader() string {
+	return n.getLeader()
+}
+
+/*
+ * Members returns a copy of the membership list
+ */
+func (n *Node) Members() []Member {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return append([]Member(nil), n.membershipGroup...)
+}
+
+/*
+ * Put stores the local file at local_path in FS513 under fs513_name, as a new version if it exists.
+ * replication sets the replicas kept of the file, 0 keeps those of an existing file and uses the
+ * replication of the config for a new one. Returns once write_quorum replicas stored it
+ */
+func (n *Node) Put(local_path string, fs513_name string, replication int) error {
+	start := time.Now()
+	err := n.addFileToFS(local_path, fs513_name, replication)
+	n.metrics.operation(OP_PUT, time.Since(start), err)
+	return err
+}
+
+/*
+ * Get copies the newest version of fs513_name a read quorum of its replicas holds into the storage
+ * directory of this node
+ */
+func (n *Node) Get(fs513_name string) error {
+	start := time.Now()
+	_, err := n.getFileFromDest(fs513_name)
+	n.metrics.operation(OP_GET, time.Since(start), err)
+	return err
+}
+
+/*
+ * Fetch copies the latest version of fs513_name to the local path dest, getting it from the replicas first
+ * if this node has no copy. Returns once dest is written
+ */
+func (n *Node) Fetch(fs513_name string, dest string) error {
+	start := time.Now()
+	err := n.fetchFile(fs513_name, dest)
+	n.metrics.operation(OP_GET, time.Since(start), err)
+	return err
+}
+
+/*
+ * GetVersions writes the last k versions of fs513_name to the local path dest, newest first, each
+ * preceded by a VERSION_DELIMITER line
+ */
+func (n *Node) GetVersions(fs513_name string, k int, dest string) error {
+	start := time.Now()
+	err := n.fetchVersions(fs513_name, k, dest)
+	n.metrics.operation(OP_GET, time.Since(start), err)
+	return err
+}
+
+/*
+ * Remove deletes fs513_name and every replica of it
+ */
+func (n *Node) Remove(fs513_name string) error {
+	start := time.Now()
+	err := n.deleteFileFromFS(fs513_name)
+	n.metrics.operation(OP_DELETE, time.Since(start), err)
+	return err
+}
+
+/*
+ * SetReplication changes the replicas kept of fs513_name. Returns once the leader has asked for the
+ * missing copies or the removal of the extra ones
+ */
+func (n *Node) SetReplication(fs513_name string, replication int) error {
+	if err := n.validReplication(replication); err != nil {
+		return err
+	}
+	req := replicationRequest{fs513_name, replication}
+	_, err := n.gatewayRequest(n.getLeader(), gatewayRequest{Replication: &req})
+	return err
+}
+
+/*
+ * Locate returns the hosts holding a replica of fs513_name
+ */
+func (n *Node) Locate(fs513_name string) []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return append([]string(nil), n.fs513_list[fs513_name].Replicas...)
+}
+
+/*
+ * Files returns a copy of the file list, the replica locations of every file
+ */
+func (n *Node) Files() map[string][]string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	files := make(map[string][]string)
+	for k, v := range n.fs513_list {
+		files[k] = append([]string(nil), v.Replicas...)
+	}
+	return files
+}
+
+/*
+ * LocalFiles returns the files this node holds a replica of according to the file list
+ */
+func (n *Node) LocalFiles() []string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.getLocalFiles()
+}
+
+/*
+ * StorageDir returns the local directory holding the replicas of this node
+ */
+func (n *Node) StorageDir() string {
+	return n.storageDir
+}
@@ -0,0 +1,281 @@
+package fs513
+
+import (
+	"config"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"transport"
+)
+
+/*
+ * Test clusters run on a simulated network. Node i is 10.0.0.<i+1>:TEST_PORT, the first node is the
+ * introducer, and the timings are short enough for a test to watch failures being detected and leaders
+ * being elected within a few seconds.
+ */
+const (
+	TEST_PORT    = 6000
+	TEST_TIMEOUT = 5 * time.Second
+)
+
+func testHost(i int) string {
+	return "10.0.0." + strconv.Itoa(i+1)
+}
+
+func testID(i int) string {
+	return testHost(i) + ":" + strconv.Itoa(TEST_PORT)
+}
+
+func testConfig(t *testing.T, i int, size int) *config.Config {
+	dir, err := ioutil.TempDir("", "fs513-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Registered before the node is stopped, so it runs after the node closed its files
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	conf := config.Default()
+	conf.Introducer = testID(0)
+	for j := 1; j < size; j++ {
+		conf.Seeds = append(conf.Seeds, testID(j))
+	}
+	conf.AdvertiseAddr = testHost(i)
+	conf.ListenAddr = testHost(i)
+	conf.AdminAddr = ""
+	conf.ControlSocket = ""
+	conf.Port = TEST_PORT
+	conf.StoragePath = filepath.Join(dir, "files")
+	conf.LogPath = filepath.Join(dir, "node.log")
+	conf.ProbeInterval = config.Duration{Duration: 100 * time.Millisecond}
+	conf.AckTimeout = config.Duration{Duration: 40 * time.Millisecond}
+	conf.SuspectTimeout = config.Duration{Duration: 400 * time.Millisecond}
+	conf.ElectionTimeout = config.Duration{Duration: 200 * time.Millisecond}
+	conf.MinGroupSize = 2
+	conf.ClusterKeys = []string{"fs513-test-cluster-key"}
+	return conf
+}
+
+/*
+ * Create node i of a cluster of size nodes on network without starting it. configure, if not nil, may
+ * change its settings
+ */
+func newTestNode(t *testing.T, network *transport.Network, i int, size int, configure func(*config.Config)) *Node {
+	conf := testConfig(t, i, size)
+	if configure != nil {
+		configure(conf)
+	}
+	n, err := NewWithTransport(conf, network.Node(testHost(i)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+/*
+ * Start node i and have it join the cluster
+ */
+func startTestNode(t *testing.T, network *transport.Network, i int, size int, configure func(*config.Config)) *Node {
+	n := newTestNode(t, network, i, size, configure)
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Stop)
+	if err := n.Join(); err != nil {
+		t.Fatalf("%s: %v", n.ID(), err)
+	}
+	return n
+}
+
+/*
+ * Start a cluster of size nodes and wait until every node knows every other
+ */
+func startCluster(t *testing.T, network *transport.Network, size int, configure func(*config.Config)) []*Node {
+	nodes := make([]*Node, size)
+	for i := range nodes {
+		nodes[i] = startTestNode(t, network, i, size, configure)
+	}
+	for _, n := range nodes {
+		waitForMembers(t, n, size)
+	}
+	return nodes
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(TEST_TIMEOUT)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func waitForMembers(t *testing.T, n *Node, size int) {
+	t.Helper()
+	waitFor(t, n.ID()+" to see "+strconv.Itoa(size)+" members", func() bool {
+		return len(n.Members()) == size
+	})
+}
+
+func hasMember(n *Node, host string) bool {
+	for _, m := range n.Members() {
+		if m.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJoinSpreadsMembership(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 4, nil)
+	for _, n := range nodes {
+		if state := n.State(); state != STATE_ACTIVE {
+			t.Errorf("%s is %s, want %s", n.ID(), state, STATE_ACTIVE)
+		}
+		if leader := n.Self().Leader; leader != testID(0) {
+			t.Errorf("%s has leader %s, want the introducer %s", n.ID(), leader, testID(0))
+		}
+	}
+}
+
+/*
+ * After a restart of the whole cluster every seed answers the introducer, but none of them is part of a
+ * group, so the introducer forms a new one
+ */
+func TestJoinAfterEveryNodeRestarted(t *testing.T) {
+	network := transport.NewNetwork()
+	nodes := make([]*Node, 4)
+	for i := range nodes {
+		nodes[i] = newTestNode(t, network, i, len(nodes), nil)
+		if err := nodes[i].Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nodes[i].Stop)
+	}
+
+	if err := nodes[1].Join(); err != errNoGroup {
+		t.Errorf("join of %s before the introducer: %v, want %v", nodes[1].ID(), err, errNoGroup)
+	}
+	for _, n := range nodes {
+		if err := n.Join(); err != nil {
+			t.Fatalf("%s: %v", n.ID(), err)
+		}
+	}
+	for _, n := range nodes {
+		waitForMembers(t, n, len(nodes))
+	}
+}
+
+/*
+ * A node configured for another cluster is refused by the gateway, even with the same cluster key
+ */
+func TestJoinRejectsOtherCluster(t *testing.T) {
+	network := transport.NewNetwork()
+	nodes := startCluster(t, network, 2, nil)
+	other := newTestNode(t, network, 2, 3, func(conf *config.Config) {
+		conf.ClusterName = "other"
+	})
+	if err := other.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(other.Stop)
+
+	err := other.Join()
+	if err == nil || !strings.Contains(err.Error(), "cluster name mismatch") {
+		t.Fatalf("join of a node of another cluster: %v, want a cluster name mismatch", err)
+	}
+	if state := other.State(); state != STATE_LEFT {
+		t.Errorf("node of another cluster is %s, want %s", state, STATE_LEFT)
+	}
+	for _, n := range nodes {
+		if hasMember(n, other.ID()) {
+			t.Errorf("%s added the node of another cluster", n.ID())
+		}
+	}
+}
+
+func TestLeaveAndRejoin(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 4, nil)
+	leaving := nodes[2]
+	before := leaving.Self().Incarnation
+	if err := leaving.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*Node{nodes[0], nodes[1], nodes[3]} {
+		waitForMembers(t, n, 3)
+	}
+
+	if err := leaving.Join(); err != nil {
+		t.Fatal(err)
+	}
+	if after := leaving.Self().Incarnation; after <= before {
+		t.Errorf("rejoined with incarnation %d, want one above %d", after, before)
+	}
+	for _, n := range nodes {
+		waitForMembers(t, n, 4)
+	}
+}
+
+/*
+ * A restart takes an incarnation above the saved one even when the clock is behind it, and clean_storage
+ * keeps it
+ */
+func TestIncarnationSurvivesRestart(t *testing.T) {
+	network := transport.NewNetwork()
+	first := newTestNode(t, network, 1, 3, nil)
+	dir := first.conf.StoragePath
+	first.refute(first.Self().Incarnation + uint64(time.Hour))
+	refuted := first.Self().Incarnation
+	first.Stop()
+
+	restarted := newTestNode(t, network, 1, 3, func(conf *config.Config) {
+		conf.StoragePath = dir
+		conf.CleanStorage = true
+	})
+	if err := restarted.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(restarted.Stop)
+	if _, err := os.Stat(filepath.Join(restarted.storageDir, INCARNATION_FILE)); err != nil {
+		t.Errorf("saved incarnation after clean_storage: %v", err)
+	}
+	if incarnation := restarted.Self().Incarnation; incarnation <= refuted {
+		t.Errorf("restarted with incarnation %d, want one above %d", incarnation, refuted)
+	}
+	if validName(INCARNATION_FILE) {
+		t.Errorf("%s is accepted as a file name", INCARNATION_FILE)
+	}
+}
+
+/*
+ * A member restarting with empty storage before it is found failed gets its replicas copied back
+ */
+func TestRestartWithEmptyStorage(t *testing.T) {
+	network := transport.NewNetwork()
+	nodes := startCluster(t, network, 4, nil)
+	if err := nodes[1].Put(writeTestFile(t, "kept"), "kept.txt", 3); err != nil {
+		t.Fatal(err)
+	}
+	holdAll := func(nodes []*Node) func() bool {
+		return func() bool {
+			for _, n := range nodes {
+				if contains(nodes[0].Locate("kept.txt"), n.ID()) && len(n.localVersions("kept.txt")) == 0 {
+					return false
+				}
+			}
+			return len(nodes[0].Locate("kept.txt")) == 3
+		}
+	}
+	waitFor(t, "every replica of kept.txt to hold it", holdAll(nodes))
+
+	// Restarted in a new storage directory, as if clean_storage had emptied it
+	nodes[2].Stop()
+	nodes[2] = startTestNode(t, network, 2, 4, nil)
+	waitFor(t, "every replica of kept.txt to hold it after the restart", holdAll(nodes))
+}
@@ -0,0 +1,163 @@
+package fs513
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Prometheus text exposition format, version 0.0.4. Every metric is prefixed with fs513_, durations are
+ * in seconds. The gauge fs513_under_replicated_files is only exported by the gateway.
+ */
+const PROMETHEUS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+type promWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+/*
+ * WritePrometheus writes the snapshot in Prometheus text format
+ */
+func (m Metrics) WritePrometheus(out io.Writer) error {
+	p := &promWriter{w: bufio.NewWriter(out)}
+
+	p.header("fs513_leader", "gauge", "1 if this node is the gateway")
+	p.sample("fs513_leader", "", boolValue(m.Leader))
+	p.header("fs513_membership_size", "gauge", "Members in the membership list of this node")
+	p.sample("fs513_membership_size", "", float64(m.Members))
+	p.header("fs513_local_replicas", "gauge", "Files this node holds a replica of")
+	p.sample("fs513_local_replicas", "", float64(m.LocalReplicas))
+	if m.Leader {
+		p.header("fs513_under_replicated_files", "gauge", "Files with fewer replicas on members than wanted")
+		p.sample("fs513_under_replicated_files", "", float64(m.UnderReplicated))
+	}
+
+	p.counters("fs513_msgs_sent_total", "Frames sent by msg type", "type", m.MsgsSent)
+	p.counters("fs513_msgs_received_total", "Authenticated frames received by msg type", "type", m.MsgsReceived)
+	p.counters("fs513_bytes_sent_total", "Bytes written by port", "port", m.BytesSent)
+	p.counters("fs513_bytes_received_total", "Bytes read by port", "port", m.BytesReceived)
+	p.header("fs513_rejected_frames_total", "counter", "Frames which failed authentication or decoding")
+	p.sample("fs513_rejected_frames_total", "", float64(m.Rejected))
+
+	p.header("fs513_ack_rtt_seconds", "histogram", "Round trip of a SYN to its direct ACK")
+	p.histogram("fs513_ack_rtt_seconds", "", m.AckRTT)
+	p.header("fs513_member_ack_rtt_seconds", "histogram", "Round trip of a SYN to its direct ACK by probed member")
+	for _, host := range sortedKeys(m.AckRTTByMember) {
+		p.histogram("fs513_member_ack_rtt_seconds", label("member", host), m.AckRTTByMember[host])
+	}
+	p.header("fs513_detection_seconds", "histogram", "Time from the last ACK of a member to declaring it failed")
+	p.histogram("fs513_detection_seconds", "", m.DetectionTime)
+	p.header("fs513_false_positives_total", "counter", "Members declared failed and heard from again")
+	p.sample("fs513_false_positives_total", "", float64(m.FalsePositives))
+
+	p.header("fs513_op_duration_seconds", "histogram", "Latency of file operations issued on this node")
+	for _, op := range sortedKeys(m.Ops) {
+		p.histogram("fs513_op_duration_seconds", label("op", op), m.Ops[op].Latency)
+	}
+	p.header("fs513_op_errors_total", "counter", "Failed file operations issued on this node")
+	for _, op := range sortedKeys(m.Ops) {
+		p.sample("fs513_op_errors_total", label("op", op), float64(m.Ops[op].Errors))
+	}
+
+	p.header("fs513_transfers_total", "counter", "Replicas copied to other nodes")
+	p.sample("fs513_transfers_total", "", float64(m.Transfers.Count))
+	p.header("fs513_transfer_failures_total", "counter", "Replica copies which failed")
+	p.sample("fs513_transfer_failures_total", "", float64(m.Transfers.Failed))
+	p.header("fs513_transfer_bytes_total", "counter", "Bytes of replicas copied to other nodes")
+	p.sample("fs513_transfer_bytes_total", "", float64(m.Transfers.Bytes))
+	p.header("fs513_transfer_seconds_total", "counter", "Time spent copying replicas")
+	p.sample("fs513_transfer_seconds_total", "", m.Transfers.Seconds)
+	p.header("fs513_bad_replicas_total", "counter", "Local copies found not to match their checksum")
+	p.sample("fs513_bad_replicas_total", "", float64(m.Transfers.BadReplicas))
+
+	if p.err != nil {
+		return p.err
+	}
+	return p.w.Flush()
+}
+
+func (p *promWriter) write(s string) {
+	if p.err == nil {
+		_, p.err = p.w.WriteString(s)
+	}
+}
+
+func (p *promWriter) header(name string, kind string, help string) {
+	p.write("# HELP " + name + " " + help + "\n")
+	p.write("# TYPE " + name + " " + kind + "\n")
+}
+
+/*
+ * One sample line, labels is empty or a comma separated list of name="value" pairs
+ */
+func (p *promWriter) sample(name string, labels string, v float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	p.write(name + " " + strconv.FormatFloat(v, 'g', -1, 64) + "\n")
+}
+
+func (p *promWriter) counters(name string, help string, labelName string, counts map[string]uint64) {
+	p.header(name, "counter", help)
+	for _, k := range sortedKeys(counts) {
+		p.sample(name, label(labelName, k), float64(counts[k]))
+	}
+}
+
+/*
+ * Prometheus buckets are cumulative, ours count each observation once
+ */
+func (p *promWriter) histogram(name string, labels string, h Histogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Buckets[i]
+		p.sample(name+"_bucket", labels+sep+label("le", strconv.FormatFloat(bound, 'g', -1, 64)), float64(cumulative))
+	}
+	p.sample(name+"_bucket", labels+sep+label("le", "+Inf"), float64(h.Count))
+	p.sample(name+"_sum", labels, h.Sum)
+	p.sample(name+"_count", labels, float64(h.Count))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name string, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+/*
+ * Keys of a map with string keys in order, so that scrapes list the series in the same order
+ */
+func sortedKeys(m interface{}) []string {
+	keys := make([]string, 0)
+	switch v := m.(type) {
+	case map[string]uint64:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]Histogram:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]OpStats:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
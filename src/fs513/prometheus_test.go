@@ -0,0 +1,99 @@
+package fs513
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"transport"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	m := newMetrics()
+	m.msgSent(MSG_SYN)
+	m.msgSent(MSG_SYN)
+	m.msgReceived(MSG_ACK)
+	m.ackReceived("10.0.0.2:6000", 3*time.Millisecond)
+	m.ackReceived("10.0.0.2:6000", 30*time.Millisecond)
+	m.operation(OP_PUT, 20*time.Millisecond, errors.New("no quorum"))
+	snapshot := m.snapshot()
+	snapshot.Members = 3
+
+	out := &bytes.Buffer{}
+	if err := snapshot.WritePrometheus(out); err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+	for _, line := range []string{
+		"fs513_leader 0",
+		"fs513_membership_size 3",
+		`fs513_msgs_sent_total{type="SYN"} 2`,
+		`fs513_msgs_received_total{type="ACK"} 1`,
+		`fs513_ack_rtt_seconds_bucket{le="0.0025"} 0`,
+		`fs513_ack_rtt_seconds_bucket{le="0.005"} 1`,
+		`fs513_ack_rtt_seconds_bucket{le="0.05"} 2`,
+		`fs513_ack_rtt_seconds_bucket{le="+Inf"} 2`,
+		"fs513_ack_rtt_seconds_count 2",
+		`fs513_member_ack_rtt_seconds_bucket{member="10.0.0.2:6000",le="+Inf"} 2`,
+		`fs513_op_duration_seconds_count{op="put"} 1`,
+		`fs513_op_errors_total{op="put"} 1`,
+	} {
+		if !strings.Contains(text, "\n"+line+"\n") {
+			t.Errorf("no line %s in\n%s", line, text)
+		}
+	}
+	if strings.Contains(text, "fs513_under_replicated_files") {
+		t.Error("under replicated files exported by a node which is not the gateway")
+	}
+
+	// Every sample belongs to a family declared by a TYPE line before it
+	types := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if strings.HasPrefix(line, "# TYPE ") {
+			types[fields[2]] = fields[3]
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.SplitN(fields[0], "{", 2)[0]
+		family := name
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if base := strings.TrimSuffix(name, suffix); base != name && types[base] == "histogram" {
+				family = base
+			}
+		}
+		if _, ok := types[family]; !ok {
+			t.Errorf("sample %s without a TYPE line", line)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	n := newTestNode(t, transport.NewNetwork(), 0, 1, nil)
+	t.Cleanup(n.Stop)
+	rec := httptest.NewRecorder()
+	n.handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != PROMETHEUS_CONTENT_TYPE {
+		t.Errorf("content type %q, want %q", ct, PROMETHEUS_CONTENT_TYPE)
+	}
+	if !strings.Contains(rec.Body.String(), "\nfs513_membership_size 1\n") {
+		t.Errorf("no membership size of 1 in\n%s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	n.handleMetrics(rec, httptest.NewRequest("POST", "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST answered with %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	if got, want := label("path", "a\"b\\c\nd"), `path="a\"b\\c\nd"`; got != want {
+		t.Errorf("label is %s, want %s", got, want)
+	}
+}
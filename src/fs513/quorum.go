@@ -0,0 +1,200 @@
+package fs513
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+/*
+ * Quorum reads and writes. A put succeeds once write_quorum replicas of the file stored the new version,
+ * the copies to the other replicas finish in the background. A get asks replicas for the newest version
+ * they hold until read_quorum of them answered and fetches the newest of those, or the latest version in
+ * the file list if that is newer. With write_quorum + read_quorum above the replica count every read
+ * quorum overlaps every write quorum, so a get returns the last completed put even when the file list has
+ * not caught up yet or a replica failed since. Each file gets quorums overlapping for its own replica
+ * count, see quorums.
+ *
+ * A put that misses its quorum is not added to the file list, but the replicas which did store it keep
+ * their copy and a get reading one of them may return it.
+ */
+
+/*
+ * Write and read quorum of a file with the given number of replicas. write_quorum and read_quorum are
+ * capped at the replicas, then raised in turn until they add up to more than the replicas, so a file
+ * with 5 replicas gets quorums of 3 with the default quorums of 2
+ */
+func (n *Node) quorums(replicas int) (int, int) {
+	w, r := n.conf.WriteQuorum, n.conf.ReadQuorum
+	if w > replicas {
+		w = replicas
+	}
+	if r > replicas {
+		r = replicas
+	}
+	for replicas > 0 && w+r <= replicas {
+		if w <= r {
+			w++
+		} else {
+			r++
+		}
+	}
+	return w, r
+}
+
+/*
+ * Copy version of fs513_name from this node to replicas and return once w of them hold it, counting this
+ * node if it is one of them. done is called once every copy finished
+ */
+func (n *Node) writeQuorum(fs513_name string, version uint64, replicas []string, done func()) error {
+	w, _ := n.quorums(len(replicas))
+	if w != n.conf.WriteQuorum {
+		n.infolog.Println("Write quorum of " + fs513_name + " is " + strconv.Itoa(w) + " for its " + strconv.Itoa(len(replicas)) + " replicas")
+	}
+
+	results := make(chan error, len(replicas))
+	acks, pending := 0, 0
+	for _, host := range replicas {
+		if host == n.currHost {
+			acks++
+			continue
+		}
+		pending++
+		go func(host string) {
+			err := n.pushFile(fs513_name, version, host)
+			if err != nil {
+				n.errlog.Println("Not able to copy "+fs513_name+" to "+host+":", err)
+			}
+			results <- err
+		}(host)
+	}
+
+	var last error
+	for acks < w && pending > 0 {
+		if last = <-results; last == nil {
+			acks++
+		}
+		pending--
+	}
+	go func(pending int) {
+		for ; pending > 0; pending-- {
+			<-results
+		}
+		done()
+	}(pending)
+
+	if acks < w {
+		return fmt.Errorf("write quorum not reached, %d of %d replicas stored %s: %v", acks, w, fs513_name, last)
+	}
+	return nil
+}
+
+type replicaAnswer struct {
+	host   string
+	header fileHeader
+	err    error
+}
+
+/*
+ * Ask every replica at once for the newest version of fs513_name it holds and return once r of them
+ * answered. Returns the newest version found and the replicas holding it
+ */
+func (n *Node) readQuorum(fs513_name string, replicas []string) (fileVersion, []string, error) {
+	_, r := n.quorums(len(replicas))
+	if r != n.conf.ReadQuorum {
+		n.infolog.Println("Read quorum of " + fs513_name + " is " + strconv.Itoa(r) + " for its " + strconv.Itoa(len(replicas)) + " replicas")
+	}
+
+	results := make(chan replicaAnswer, len(replicas))
+	for _, host := range replicas {
+		go func(host string) {
+			a := replicaAnswer{host: host}
+			if host == n.currHost {
+				a.header, a.err = n.newestReplica(fs513_name)
+			} else {
+				a.header, a.err = n.queryNewest(fs513_name, host)
+			}
+			results <- a
+		}(host)
+	}
+
+	newest, holders := fileVersion{}, make([]string, 0)
+	answers := 0
+	for pending := len(replicas); answers < r && pending > 0; pending-- {
+		a := <-results
+		if a.err != nil {
+			n.errlog.Println("No version of "+fs513_name+" from "+a.host+":", a.err)
+			continue
+		}
+		answers++
+		if a.header.Version > newest.Version {
+			newest, holders = fileVersion{a.header.Version, a.header.Checksum}, []string{a.host}
+		} else if a.header.Version == newest.Version {
+			holders = append(holders, a.host)
+		}
+	}
+	if answers < r {
+		return newest, holders, fmt.Errorf("read quorum not reached, %d of %d replicas of %s answered", answers, r, fs513_name)
+	}
+	return newest, holders, nil
+}
+
+/*
+ * Newest version of the file described by meta, by a read quorum of its replicas or by the file list if
+ * the list knows a newer one. Returns the version and the replicas to get it from
+ */
+func (n *Node) newestVersion(fs513_name string, meta fileMeta) (fileVersion, []string, error) {
+	newest, holders, err := n.readQuorum(fs513_name, meta.Replicas)
+	if err != nil {
+		return newest, holders, err
+	}
+	if latest := meta.latest(); latest.Version > newest.Version {
+		return latest, meta.Replicas, nil
+	}
+	return newest, holders, nil
+}
+
+/*
+ * Header of the newest version of fs513_name held by this node, its content is not read beyond the checksum
+ */
+func (n *Node) newestReplica(fs513_name string) (fileHeader, error) {
+	versions := n.localVersions(fs513_name)
+	if len(versions) == 0 {
+		return fileHeader{}, errors.New("no version of " + fs513_name + " on " + n.currHost)
+	}
+	file, header, err := n.openReplica(fs513_name, versions[len(versions)-1])
+	if err != nil {
+		return fileHeader{}, err
+	}
+	file.Close()
+	return header, nil
+}
+
+/*
+ * Ask host for the header of the newest version of fs513_name it holds
+ */
+func (n *Node) queryNewest(fs513_name string, host string) (fileHeader, error) {
+	conn, err := n.dialTransfer(host)
+	if err != nil {
+		return fileHeader{}, err
+	}
+	defer conn.Close()
+	if err := n.writeValue(conn, n.nextRequestID(), fileRequest{fs513_name, 0}); err != nil {
+		return fileHeader{}, err
+	}
+	result := fileResult{}
+	if _, err := n.readValue(conn, &result); err != nil {
+		return fileHeader{}, err
+	}
+	if result.Error != "" {
+		return fileHeader{}, errors.New(host + ": " + result.Error)
+	}
+	header := fileHeader{}
+	if _, err := n.readValue(conn, &header); err != nil {
+		return fileHeader{}, err
+	}
+	if header.Name != fs513_name {
+		return fileHeader{}, fmt.Errorf("asked %s for %s, got %s", host, fs513_name, header.Name)
+	}
+	return header, nil
+}
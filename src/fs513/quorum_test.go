@@ -0,0 +1,95 @@
+package fs513
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"transport"
+)
+
+/*
+ * Write content to a new local file and return its path
+ */
+func writeTestFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "local")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func fetchContent(t *testing.T, n *Node, fs513_name string) string {
+	t.Helper()
+	dest := filepath.Join(t.TempDir(), "fetched")
+	if err := n.Fetch(fs513_name, dest); err != nil {
+		t.Fatalf("%s: fetch %s: %v", n.ID(), fs513_name, err)
+	}
+	content, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+/*
+ * A put returns once the leader recorded the version, and the node it ran on finds the file before the
+ * file list sent by the leader arrived
+ */
+func TestFetchRightAfterPut(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 4, nil)
+	for i := 0; i < 10; i++ {
+		name := "file" + strconv.Itoa(i)
+		content := "content of " + name
+		if err := nodes[2].Put(writeTestFile(t, content), name, 0); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			// As if the file list sent by the leader had not arrived yet
+			nodes[2].mutex.Lock()
+			delete(nodes[2].fs513_list, name)
+			nodes[2].mutex.Unlock()
+		}
+		if got := fetchContent(t, nodes[2], name); got != content {
+			t.Errorf("fetched %q for %s, want %q", got, name, content)
+		}
+	}
+	if _, err := nodes[1].getFileFromDest("missing"); err != errNotFound("missing") {
+		t.Errorf("get of a file never put: %v, want %v", err, errNotFound("missing"))
+	}
+}
+
+/*
+ * With replication 3 a put and a get need 2 replicas: they go through with one replica cut off, and a put
+ * fails with two
+ */
+func TestQuorumsWithReplicasCutOff(t *testing.T) {
+	network := transport.NewNetwork()
+	nodes := startCluster(t, network, 4, nil)
+	if err := nodes[0].Put(writeTestFile(t, "v1"), "a.txt", 3); err != nil {
+		t.Fatal(err)
+	}
+	others := make([]string, 0)
+	for i, n := range nodes {
+		if n != nodes[0] && contains(nodes[0].Locate("a.txt"), n.ID()) {
+			others = append(others, testHost(i))
+		}
+	}
+	if len(others) != 2 {
+		t.Fatalf("a.txt put on %s is on %v, want it and 2 others", nodes[0].ID(), nodes[0].Locate("a.txt"))
+	}
+	everyone := []string{testHost(0), testHost(1), testHost(2), testHost(3)}
+
+	network.Partition(others[:1], everyone)
+	if err := nodes[0].Put(writeTestFile(t, "v2"), "a.txt", 0); err != nil {
+		t.Fatalf("put with one of 3 replicas cut off: %v", err)
+	}
+	if got := fetchContent(t, nodes[0], "a.txt"); got != "v2" {
+		t.Errorf("fetched %q with one of 3 replicas cut off, want \"v2\"", got)
+	}
+
+	network.Partition(others, everyone)
+	if err := nodes[0].Put(writeTestFile(t, "v3"), "a.txt", 0); err == nil {
+		t.Error("put with two of 3 replicas cut off succeeded")
+	}
+}
@@ -0,0 +1,92 @@
+package fs513
+
+import (
+	"testing"
+	"transport"
+)
+
+func TestQuorums(t *testing.T) {
+	n := newTestNode(t, transport.NewNetwork(), 0, 1, nil)
+	t.Cleanup(n.Stop)
+	cases := []struct{ replicas, w, r int }{
+		{1, 1, 1},
+		{2, 2, 2},
+		{3, 2, 2},
+		{4, 3, 2},
+		{5, 3, 3},
+		{9, 5, 5},
+	}
+	for _, c := range cases {
+		if w, r := n.quorums(c.replicas); w != c.w || r != c.r {
+			t.Errorf("quorums of %d replicas are %d and %d, want %d and %d", c.replicas, w, r, c.w, c.r)
+		}
+	}
+}
+
+/*
+ * A replication above write_quorum + read_quorum is served with larger quorums instead of being refused
+ */
+func TestReplicationFive(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 5, nil)
+	if err := nodes[1].Put(writeTestFile(t, "five"), "five.txt", 5); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		waitFor(t, n.ID()+" to list 5 replicas of five.txt", func() bool {
+			info, _ := n.Stat("five.txt")
+			return len(info.Replicas) == 5
+		})
+	}
+	if got := fetchContent(t, nodes[3], "five.txt"); got != "five" {
+		t.Errorf("fetched %q, want \"five\"", got)
+	}
+
+	if err := nodes[2].Put(writeTestFile(t, "three"), "three.txt", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[4].SetReplication("three.txt", 5); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "three.txt to have 5 replicas", func() bool {
+		info, _ := nodes[0].Stat("three.txt")
+		return len(info.Replicas) == 5
+	})
+}
+
+/*
+ * Lowering the replication drops the last replicas from the list and has them remove their copies
+ */
+func TestSetReplicationDown(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 4, nil)
+	if err := nodes[1].Put(writeTestFile(t, "fewer"), "a.txt", 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		waitFor(t, n.ID()+" to hold a.txt", func() bool {
+			return len(n.localVersions("a.txt")) > 0
+		})
+	}
+	if err := nodes[3].SetReplication("a.txt", 0); err == nil {
+		t.Error("replication 0 was accepted")
+	}
+
+	if err := nodes[3].SetReplication("a.txt", 2); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a.txt to be kept by 2 members only", func() bool {
+		replicas := nodes[0].Locate("a.txt")
+		if len(replicas) != 2 {
+			return false
+		}
+		for _, n := range nodes {
+			if held := len(n.localVersions("a.txt")) > 0; held != contains(replicas, n.ID()) {
+				return false
+			}
+		}
+		return true
+	})
+	waitFor(t, nodes[2].ID()+" to list the replication of a.txt as 2", func() bool {
+		info, _ := nodes[2].Stat("a.txt")
+		return info.Replication == 2
+	})
+}
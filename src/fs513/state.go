@@ -0,0 +1,142 @@
+package fs513
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * Full state transfers and requests which need an answer, such as the join handshake, go over a stream
+ * instead of a single datagram, so they have no size limit. Each connection carries one frame and, for
+ * requests, one reply frame with the same request ID.
+ */
+const STATE_TIMEOUT = time.Second * 5 // Deadline for sending or receiving one state transfer
+
+/*
+ * The file list is versioned by the leader, so a receiver can drop a list which arrives after a newer one
+ */
+type fileList struct {
+	Version uint64
+	Files   map[string]fileMeta
+}
+
+/*
+ * Send v to the listener at the given port offset of host
+ */
+func (n *Node) sendState(host string, offset int, v interface{}) error {
+	conn, err := n.dial(host, offset)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(STATE_TIMEOUT))
+	return n.writeValue(conn, n.nextRequestID(), v)
+}
+
+/*
+ * Send req to the listener at the given port offset of host and decode its reply into resp
+ */
+func (n *Node) request(host string, offset int, req interface{}, resp interface{}) error {
+	conn, err := n.dial(host, offset)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(STATE_TIMEOUT))
+	id := n.nextRequestID()
+	if err := n.writeValue(conn, id, req); err != nil {
+		return err
+	}
+	replyID, err := n.readValue(conn, resp)
+	if err == nil && replyID != id {
+		err = fmt.Errorf("reply to request %d answers request %d", id, replyID)
+	}
+	return err
+}
+
+/*
+ * Accept state transfers and requests on listener. Each decoded value is passed to handle, a non nil
+ * result is sent back as the reply. newValue returns a pointer to decode into. Returns once the listener
+ * is closed
+ */
+func (n *Node) acceptState(listener net.Listener, name string, newValue func() interface{}, handle func(interface{}) interface{}) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !n.stopping() {
+				n.errlog.Println(err)
+			}
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(STATE_TIMEOUT))
+			v := newValue()
+			id, err := n.readValue(conn, v)
+			if err != nil {
+				n.errlog.Println(name+": rejected transfer from "+conn.RemoteAddr().String()+":", err)
+				return
+			}
+			if reply := handle(v); reply != nil {
+				if err := n.writeValue(conn, id, reply); err != nil {
+					n.errlog.Println(name+": not able to reply to "+conn.RemoteAddr().String(), err)
+				}
+			}
+		}(conn)
+	}
+}
+
+func (n *Node) nextRequestID() uint64 {
+	return atomic.AddUint64(&n.requestSeq, 1)
+}
+
+func (n *Node) writeValue(conn net.Conn, id uint64, v interface{}) error {
+	t, payload, err := marshal(v)
+	if err != nil {
+		return err
+	}
+	data, err := n.auth.seal(t, id, payload)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Write(data); err != nil {
+		return err
+	}
+	n.metrics.msgSent(t)
+	return nil
+}
+
+/*
+ * Read one frame into v and return its request ID. A frame of another type than v is rejected
+ */
+func (n *Node) readValue(conn net.Conn, v interface{}) (uint64, error) {
+	f, err := n.readAnyFrame(conn)
+	if err != nil {
+		return 0, err
+	}
+	if err := n.decodeValue(f, v); err != nil {
+		return 0, err
+	}
+	return f.RequestID, nil
+}
+
+/*
+ * Read and authenticate one frame of any type, for listeners which accept several
+ */
+func (n *Node) readAnyFrame(conn net.Conn) (frame, error) {
+	data, err := readFrame(conn)
+	if err != nil {
+		return frame{}, n.auth.reject(err)
+	}
+	return n.auth.open(data)
+}
+
+func (n *Node) decodeValue(f frame, v interface{}) error {
+	if err := unmarshal(f.Type, f.RequestID, f.Payload, v); err != nil {
+		return n.auth.reject(fmt.Errorf("%s frame: %v", f.Type, err))
+	}
+	n.metrics.msgReceived(f.Type)
+	return nil
+}
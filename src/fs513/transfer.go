@@ -0,0 +1,350 @@
+package fs513
+
+import (
+	"config"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+ * File transfer service. Replicas are copied between nodes over a stream to the transfer port, each
+ * connection carrying one version of a file:
+ *
+ *   push   sender: FILE_HEADER and the content          receiver: FILE_RESULT
+ *   pull   puller: FILE_REQUEST                         holder: FILE_RESULT, then if it has the file
+ *                                                       FILE_HEADER and the content
+ *          puller: FILE_RESULT
+ *   query  asker:  FILE_REQUEST of version 0            holder: FILE_RESULT, then if it has a version
+ *                                                       FILE_HEADER of the newest one, without content
+ *
+ * The content is the size bytes given in the header, sent right after it outside of any frame. The
+ * receiver writes it to a temporary file in the directory of the file and only renames it into place
+ * once size and checksum match, so a failed transfer never leaves a partial replica behind. Both ends
+ * also hold the checksum against the one in the file list, once the version is in it: a sender whose
+ * replica does not match reports it as bad instead of spreading it.
+ */
+const TRANSFER_IDLE = time.Second * 30 // A transfer fails once no byte moved for this long
+
+type fileHeader struct {
+	Name     string
+	Size     uint64
+	Checksum string // Hex SHA-256 of the content
+	Version  uint64
+}
+
+type fileRequest struct {
+	Name    string
+	Version uint64
+}
+
+type fileResult struct {
+	Error string // Empty on success
+}
+
+/*
+ * Copy the local replica of version of fs513_name into the storage directory of host and wait for host
+ * to confirm it stored an intact copy
+ */
+func (n *Node) pushFile(fs513_name string, version uint64, host string) error {
+	file, header, err := n.openReplica(fs513_name, version)
+	if err != nil {
+		n.metrics.transfer(0, 0, err)
+		return err
+	}
+	defer file.Close()
+	conn, err := n.dialTransfer(host)
+	if err != nil {
+		n.metrics.transfer(0, 0, err)
+		return err
+	}
+	defer conn.Close()
+	return n.sendFile(conn, n.nextRequestID(), file, header)
+}
+
+/*
+ * Copy version of fs513_name from host into the storage directory of this node
+ */
+func (n *Node) pullFile(fs513_name string, version uint64, host string) error {
+	conn, err := n.dialTransfer(host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	id := n.nextRequestID()
+	if err := n.writeValue(conn, id, fileRequest{fs513_name, version}); err != nil {
+		return err
+	}
+	result := fileResult{}
+	if _, err := n.readValue(conn, &result); err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return errors.New(host + ": " + result.Error)
+	}
+	header := fileHeader{}
+	if _, err := n.readValue(conn, &header); err != nil {
+		return err
+	}
+	if header.Name != fs513_name || header.Version != version {
+		return fmt.Errorf("asked %s for version %d of %s, got version %d of %s", host, version, fs513_name, header.Version, header.Name)
+	}
+	return n.receiveFile(conn, id, header)
+}
+
+func (n *Node) dialTransfer(host string) (net.Conn, error) {
+	conn, err := n.dial(host, config.TRANSFER_OFFSET)
+	if err != nil {
+		return nil, err
+	}
+	return idleConn{conn, TRANSFER_IDLE}, nil
+}
+
+/*
+ * Accept pushes and pulls on listener. Returns once the listener is closed
+ */
+func (n *Node) serveTransfers(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !n.stopping() {
+				n.errlog.Println(err)
+			}
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			conn = idleConn{conn, TRANSFER_IDLE}
+			if err := n.handleTransfer(conn); err != nil {
+				n.errlog.Println("transfer with "+conn.RemoteAddr().String()+" failed:", err)
+			}
+		}(conn)
+	}
+}
+
+func (n *Node) handleTransfer(conn net.Conn) error {
+	f, err := n.readAnyFrame(conn)
+	if err != nil {
+		return err
+	}
+	switch f.Type {
+	case MSG_FILE_HEADER:
+		header := fileHeader{}
+		if err := n.decodeValue(f, &header); err != nil {
+			return err
+		}
+		if err := n.receiveFile(conn, f.RequestID, header); err != nil {
+			return err
+		}
+		n.infolog.Println(fmt.Sprintf("Received version %d of %s", header.Version, header.Name))
+		return nil
+	case MSG_FILE_REQUEST:
+		req := fileRequest{}
+		if err := n.decodeValue(f, &req); err != nil {
+			return err
+		}
+		if !validName(req.Name) {
+			return n.writeValue(conn, f.RequestID, fileResult{"invalid file name " + req.Name})
+		}
+		if req.Version == 0 {
+			header, err := n.newestReplica(req.Name)
+			if err != nil {
+				return n.writeValue(conn, f.RequestID, fileResult{err.Error()})
+			}
+			if err := n.writeValue(conn, f.RequestID, fileResult{}); err != nil {
+				return err
+			}
+			return n.writeValue(conn, f.RequestID, header)
+		}
+		file, header, err := n.openReplica(req.Name, req.Version)
+		if err != nil {
+			return n.writeValue(conn, f.RequestID, fileResult{"no good replica of " + req.Name + " on " + n.currHost + ": " + err.Error()})
+		}
+		defer file.Close()
+		if err := n.writeValue(conn, f.RequestID, fileResult{}); err != nil {
+			return err
+		}
+		return n.sendFile(conn, f.RequestID, file, header)
+	}
+	return n.auth.reject(fmt.Errorf("%s frame on the transfer port", f.Type))
+}
+
+/*
+ * Open the local replica of version of fs513_name and describe it in a header, positioned at the start of
+ * the content. A replica not matching the checksum in the file list is reported as bad
+ */
+func (n *Node) openReplica(fs513_name string, version uint64) (*os.File, fileHeader, error) {
+	file, err := os.Open(n.replicaPath(fs513_name, version))
+	if err != nil {
+		return nil, fileHeader{}, err
+	}
+	checksum, size, err := checksumOf(file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fileHeader{}, err
+	}
+	if want := n.listedChecksum(fs513_name, version); want != "" && checksum != want {
+		file.Close()
+		n.reportBadReplica(fs513_name, version, checksum)
+		return nil, fileHeader{}, fmt.Errorf("local replica of version %d of %s does not match its checksum", version, fs513_name)
+	}
+	return file, fileHeader{fs513_name, uint64(size), checksum, version}, nil
+}
+
+/*
+ * Write header and the content of file to conn and read the result of the receiver
+ */
+func (n *Node) sendFile(conn net.Conn, id uint64, file *os.File, header fileHeader) (err error) {
+	start := time.Now()
+	var size uint64
+	defer func() {
+		n.metrics.transfer(int64(size), time.Since(start), err)
+	}()
+
+	if err := n.writeValue(conn, id, header); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(conn, file, int64(header.Size)); err != nil {
+		return err
+	}
+
+	result := fileResult{}
+	replyID, err := n.readValue(conn, &result)
+	if err != nil {
+		return err
+	}
+	if replyID != id {
+		return fmt.Errorf("result of transfer %d answers transfer %d", id, replyID)
+	}
+	if result.Error != "" {
+		return errors.New(conn.RemoteAddr().String() + ": " + result.Error)
+	}
+	size = header.Size
+	return nil
+}
+
+/*
+ * Read the content announced by header from conn into the storage directory and send the result back
+ */
+func (n *Node) receiveFile(conn net.Conn, id uint64, header fileHeader) error {
+	err := n.storeFile(conn, header)
+	result := fileResult{}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if werr := n.writeValue(conn, id, result); werr != nil && err == nil {
+		err = werr
+	}
+	return err
+}
+
+func (n *Node) storeFile(r io.Reader, header fileHeader) error {
+	if !validName(header.Name) {
+		// Consume the content anyway so the sender gets to read the result
+		io.CopyN(ioutil.Discard, r, int64(header.Size))
+		return errors.New("invalid file name " + header.Name)
+	}
+	if header.Version == 0 {
+		io.CopyN(ioutil.Discard, r, int64(header.Size))
+		return errors.New("no version given for " + header.Name)
+	}
+	if want := n.listedChecksum(header.Name, header.Version); want != "" && header.Checksum != want {
+		io.CopyN(ioutil.Discard, r, int64(header.Size))
+		return errors.New("copy of " + header.Name + " does not match the checksum in the file list")
+	}
+	err := os.MkdirAll(n.fileDir(header.Name), 0755)
+	var tmp *os.File
+	if err == nil {
+		tmp, err = ioutil.TempFile(n.fileDir(header.Name), ".transfer.")
+	}
+	if err != nil {
+		io.CopyN(ioutil.Discard, r, int64(header.Size))
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.CopyN(io.MultiWriter(tmp, hash), r, int64(header.Size))
+	if err == nil {
+		// TempFile creates the file readable by the owner only, replicas are as readable as a local put
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && hex.EncodeToString(hash.Sum(nil)) != header.Checksum {
+		err = errors.New("checksum mismatch for " + header.Name)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), n.replicaPath(header.Name, header.Version))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+/*
+ * Checksum of version of fs513_name in the file list, empty if it is not listed yet
+ */
+func (n *Node) listedChecksum(fs513_name string, version uint64) string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.fs513_list[fs513_name].checksum(version)
+}
+
+/*
+ * Hex SHA-256 and size of the file at path
+ */
+func fileChecksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	return checksumOf(file)
+}
+
+func checksumOf(r io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+/*
+ * Every file gets a directory in the storage directory, so a name must not leave it
+ */
+func validName(fs513_name string) bool {
+	return fs513_name != "" && fs513_name != "." && fs513_name != ".." && fs513_name != INCARNATION_FILE &&
+		!strings.Contains(fs513_name, "/")
+}
+
+/*
+ * idleConn pushes the deadline of conn forward on every read and write, so a transfer of any size only
+ * fails once it stalls
+ */
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
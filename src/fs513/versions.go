@@ -0,0 +1,362 @@
+package fs513
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+/*
+ * Versioned files. A put on an existing name adds a new version instead of failing. The leader hands out
+ * version numbers, so two nodes putting the same name at once get different ones, and records a version
+ * once its uploader reports that a write quorum stored it, see quorum.go. Every replica holds every
+ * version kept, each in its own file <storage_path>/<name>/<version>. Beyond max_versions the oldest
+ * version is dropped from the file list, and replicas delete versions older than the oldest one listed
+ * whenever the list changes.
+ *
+ * A put therefore takes three steps: the uploader asks the leader for the next version and the replicas
+ * to write it to, copies the file to them and once enough copies are made has the leader record version
+ * and checksum. The put returns once the leader answered, so a get on the same node which runs before the
+ * file list sent by the leader arrived finds the file on its replicas, see fileEntry.
+ */
+const VERSION_DELIMITER = "=== %s version %d ===\n" // Line before every version written by get-versions
+
+type fileVersion struct {
+	Version  uint64
+	Checksum string // Hex SHA-256 of the content
+}
+
+/*
+ * Asks the leader for the version a put on Name gets
+ */
+type versionRequest struct {
+	Host        string
+	Name        string
+	Replication int // Replication to set on the file, 0 to keep it
+}
+
+type versionGrant struct {
+	Version     uint64
+	Replicas    []string // Hosts to copy the version to
+	Replication int
+}
+
+/*
+ * Asks the leader to record a version stored by a write quorum
+ */
+type commitRequest struct {
+	Host        string
+	Name        string
+	Version     uint64
+	Checksum    string
+	Replication int // Replication to set on the file, 0 to keep it
+}
+
+/*
+ * Latest version of a file, the zero version if it has none
+ */
+func (m fileMeta) latest() fileVersion {
+	if len(m.Versions) == 0 {
+		return fileVersion{}
+	}
+	return m.Versions[len(m.Versions)-1]
+}
+
+/*
+ * Checksum of the given version, empty if it is not kept
+ */
+func (m fileMeta) checksum(version uint64) string {
+	for _, v := range m.Versions {
+		if v.Version == version {
+			return v.Checksum
+		}
+	}
+	return ""
+}
+
+/*
+ * Directory holding the versions of fs513_name on this node
+ */
+func (n *Node) fileDir(fs513_name string) string {
+	return filepath.Join(n.storageDir, fs513_name)
+}
+
+func (n *Node) replicaPath(fs513_name string, version uint64) string {
+	return filepath.Join(n.fileDir(fs513_name), strconv.FormatUint(version, 10))
+}
+
+func (n *Node) reserveVersion(fs513_name string, replication int) (versionGrant, error) {
+	req := versionRequest{n.currHost, fs513_name, replication}
+	resp, err := n.gatewayRequest(n.getLeader(), gatewayRequest{Version: &req})
+	if err != nil {
+		return versionGrant{}, errors.New("put " + err.Error())
+	}
+	return *resp.Version, nil
+}
+
+/*
+ * Run by the leader: the next version of a file goes to its current replicas, the first version of a
+ * new file to the uploader and the members after it. A replication given with the request for an
+ * existing file only takes effect once the version is recorded, see addVersion. Versions handed out but
+ * not recorded yet are kept in reservations, so they are not given out twice
+ */
+func (n *Node) handleVersionRequest(req *versionRequest) gatewayResponse {
+	if !n.isLeader() {
+		return gatewayResponse{Redirect: n.getLeader()}
+	}
+	if req.Replication != 0 {
+		if err := n.validReplication(req.Replication); err != nil {
+			return gatewayResponse{Error: err.Error()}
+		}
+	}
+
+	n.mutex.Lock()
+	grant := versionGrant{}
+	meta, exists := n.fs513_list[req.Name]
+	if exists {
+		grant = versionGrant{meta.latest().Version, append([]string(nil), meta.Replicas...), n.replication(meta)}
+	} else {
+		grant.Replication = req.Replication
+		if grant.Replication == 0 {
+			grant.Replication = n.conf.Replication
+		}
+		grant.Replicas = n.placement(req.Host, grant.Replication)
+	}
+	if reserved, ok := n.reservations[req.Name]; ok && reserved.Version > grant.Version {
+		grant.Version = reserved.Version
+		if !exists {
+			grant.Replicas, grant.Replication = reserved.Replicas, reserved.Replication
+		}
+	}
+	grant.Version++
+	n.reservations[req.Name] = grant
+	n.mutex.Unlock()
+
+	n.infolog.Println("Version " + strconv.FormatUint(grant.Version, 10) + " of " + req.Name + " handed to " + req.Host)
+	return gatewayResponse{Version: &grant}
+}
+
+/*
+ * The first replication replicas of a new file put on host: host and the members following it on the
+ * ring. Must be called with mutex held
+ */
+func (n *Node) placement(host string, replication int) []string {
+	ix := n.getIdxOfHost(host)
+	if ix == -1 {
+		ix = n.getIx()
+	}
+	replicas := make([]string, 0, replication)
+	for i := 0; i < len(n.membershipGroup) && len(replicas) < replication; i++ {
+		replicas = append(replicas, n.membershipGroup[(ix+i)%len(n.membershipGroup)].Host)
+	}
+	return replicas
+}
+
+/*
+ * Have the leader record version v of fs513_name and set the replication of the file, unless it is 0.
+ * Returns once the leader recorded it
+ */
+func (n *Node) commitVersion(fs513_name string, v fileVersion, replication int) error {
+	req := commitRequest{n.currHost, fs513_name, v.Version, v.Checksum, replication}
+	if _, err := n.gatewayRequest(n.getLeader(), gatewayRequest{Commit: &req}); err != nil {
+		return errors.New("put " + err.Error())
+	}
+	return nil
+}
+
+func (n *Node) handleCommit(req *commitRequest) gatewayResponse {
+	if !n.isLeader() {
+		return gatewayResponse{Redirect: n.getLeader()}
+	}
+	n.addVersion(req.Host, req.Name, fileVersion{req.Version, req.Checksum}, req.Replication)
+	return gatewayResponse{}
+}
+
+/*
+ * Run by the leader: record version v of fs513_name put on host, drop the versions beyond max_versions,
+ * apply a replication other than 0 and broadcast the file list. Puts of one name racing each other may
+ * record their versions out of order, each is put in its place by number. The replication of a version
+ * recorded after a newer one is ignored, the newer put set it last
+ */
+func (n *Node) addVersion(host string, fs513_name string, v fileVersion, replication int) {
+	n.mutex.Lock()
+	meta, ok := n.fs513_list[fs513_name]
+	if !ok {
+		if reserved, ok := n.reservations[fs513_name]; ok {
+			meta.Replicas, meta.Replication = reserved.Replicas, reserved.Replication
+		} else {
+			// Reserved with a previous leader
+			meta.Replication = n.conf.Replication
+			meta.Replicas = n.placement(host, meta.Replication)
+		}
+	}
+	i := sort.Search(len(meta.Versions), func(i int) bool { return meta.Versions[i].Version >= v.Version })
+	if i < len(meta.Versions) && meta.Versions[i].Version == v.Version {
+		n.mutex.Unlock()
+		n.infolog.Println("Ignoring version " + strconv.FormatUint(v.Version, 10) + " of " + fs513_name + ", it is recorded already")
+		return
+	}
+	newest := i == len(meta.Versions)
+	versions := append(append(append([]fileVersion(nil), meta.Versions[:i]...), v), meta.Versions[i:]...)
+	if extra := len(versions) - n.conf.MaxVersions; extra > 0 {
+		versions = versions[extra:]
+	}
+	meta.Versions = versions
+	if newest && replication != 0 && replication != n.replication(meta) {
+		meta.Replication = replication
+		meta.Replicas = n.repairReplicas(fs513_name, meta)
+	}
+	n.fs513_list[fs513_name] = meta
+	if reserved, ok := n.reservations[fs513_name]; ok && reserved.Version <= v.Version {
+		delete(n.reservations, fs513_name)
+	}
+	n.mutex.Unlock()
+
+	n.infolog.Println("Add file " + fs513_name + " version " + strconv.FormatUint(v.Version, 10))
+	n.broadcastFileList()
+	n.pruneVersions()
+}
+
+/*
+ * Entry of fs513_name in the file list. A name missing from the list may have been put on this node just
+ * before, with the file list the leader sent since still on its way. Until the list arrives such a file
+ * is looked for on the replicas it was placed on, those of a new file put here, and listed is false
+ */
+func (n *Node) fileEntry(fs513_name string) (meta fileMeta, listed bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	meta, listed = n.fs513_list[fs513_name]
+	if !listed {
+		return fileMeta{Replicas: n.placement(n.currHost, n.conf.Replication)}, false
+	}
+	return meta.copy(), true
+}
+
+/*
+ * Remove the local copies of versions older than the oldest one kept, of replicas and of files fetched
+ * from them alike
+ */
+func (n *Node) pruneVersions() {
+	n.mutex.Lock()
+	oldest := make(map[string]uint64)
+	for name, meta := range n.fs513_list {
+		if len(meta.Versions) > 0 {
+			oldest[name] = meta.Versions[0].Version
+		}
+	}
+	n.mutex.Unlock()
+
+	for name, first := range oldest {
+		for _, version := range n.localVersions(name) {
+			if version < first {
+				os.Remove(n.replicaPath(name, version))
+				n.infolog.Println("Dropped version " + strconv.FormatUint(version, 10) + " of " + name)
+			}
+		}
+	}
+}
+
+/*
+ * Versions of fs513_name on this node, oldest first
+ */
+func (n *Node) localVersions(fs513_name string) []uint64 {
+	versions := make([]uint64, 0)
+	entries, err := ioutil.ReadDir(n.fileDir(fs513_name))
+	if err != nil {
+		return versions
+	}
+	for _, entry := range entries {
+		// Temporary files of transfers and puts are not numbers
+		if version, err := strconv.ParseUint(entry.Name(), 10, 64); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+/*
+ * Copy every version of fs513_name this node holds to host. The local copies are taken rather than the
+ * file list, which may not have caught up with a version recorded just before the copy was asked for
+ */
+func (n *Node) pushAllVersions(fs513_name string, host string) error {
+	for _, version := range n.localVersions(fs513_name) {
+		if err := n.pushFile(fs513_name, version, host); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * Write the last k versions of fs513_name to dest, newest first. The newest is found by a read quorum as
+ * for a get. Each version is preceded by a line VERSION_DELIMITER and ends with a newline
+ */
+func (n *Node) fetchVersions(fs513_name string, k int, dest string) error {
+	if k < 1 {
+		return errors.New("the number of versions must be at least 1")
+	}
+	meta, listed := n.fileEntry(fs513_name)
+	newest, holders, err := n.newestVersion(fs513_name, meta)
+	if !listed && (err != nil || newest.Version == 0) {
+		return errNotFound(fs513_name)
+	}
+	if err != nil {
+		return err
+	}
+	versions := meta.Versions
+	if newest.Version > meta.latest().Version {
+		// Stored by a write quorum, but not in the file list yet
+		versions = append(versions, newest)
+	}
+	if k < len(versions) {
+		versions = versions[len(versions)-k:]
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for i := len(versions) - 1; i >= 0; i-- {
+		replicas := meta.Replicas
+		if versions[i] == newest {
+			replicas = holders
+		}
+		path, err := n.getVersion(fs513_name, versions[i], replicas)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, VERSION_DELIMITER, fs513_name, versions[i].Version); err != nil {
+			return err
+		}
+		if err := appendVersion(out, path); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+func appendVersion(out io.Writer, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	size, err := io.Copy(out, in)
+	if err != nil || size == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := in.ReadAt(last, size-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = out.Write([]byte("\n"))
+	}
+	return err
+}
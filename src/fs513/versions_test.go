@@ -0,0 +1,90 @@
+package fs513
+
+import (
+	"config"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"transport"
+)
+
+func versionNumbers(n *Node, fs513_name string) []uint64 {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	numbers := make([]uint64, 0)
+	for _, v := range n.fs513_list[fs513_name].Versions {
+		numbers = append(numbers, v.Version)
+	}
+	return numbers
+}
+
+/*
+ * Each put of a name adds a version, and get-versions writes the last k of them newest first from any node
+ */
+func TestGetVersions(t *testing.T) {
+	nodes := startCluster(t, transport.NewNetwork(), 4, nil)
+	for i, n := range nodes[:3] {
+		if err := n.Put(writeTestFile(t, fmt.Sprintf("v%d", i+1)), "a.txt", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The older versions are taken from the file list, which the leader sends after the put returned
+	waitFor(t, nodes[3].ID()+" to list 3 versions of a.txt", func() bool {
+		return len(versionNumbers(nodes[3], "a.txt")) == 3
+	})
+
+	dest := filepath.Join(t.TempDir(), "versions")
+	if err := nodes[3].GetVersions("a.txt", 2, dest); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(VERSION_DELIMITER, "a.txt", 3) + "v3\n" + fmt.Sprintf(VERSION_DELIMITER, "a.txt", 2) + "v2\n"
+	if got, err := ioutil.ReadFile(dest); err != nil || string(got) != want {
+		t.Errorf("versions written as %q, %v, want %q", got, err, want)
+	}
+	if got := fetchContent(t, nodes[3], "a.txt"); got != "v3" {
+		t.Errorf("latest version is %q, want \"v3\"", got)
+	}
+	if err := nodes[3].GetVersions("missing", 2, dest); err != errNotFound("missing") {
+		t.Errorf("versions of a file never put: %v, want %v", err, errNotFound("missing"))
+	}
+}
+
+/*
+ * Versions recorded out of order are kept in order, a version recorded twice once, and only the newest
+ * max_versions of them
+ */
+func TestAddVersionOutOfOrder(t *testing.T) {
+	n := newTestNode(t, transport.NewNetwork(), 0, 1, func(conf *config.Config) {
+		conf.MaxVersions = 3
+	})
+	t.Cleanup(n.Stop)
+
+	steps := []struct {
+		version  uint64
+		versions []uint64
+	}{
+		{2, []uint64{2}},
+		{1, []uint64{1, 2}},
+		{1, []uint64{1, 2}},
+		{4, []uint64{1, 2, 4}},
+		{3, []uint64{2, 3, 4}},
+		{1, []uint64{2, 3, 4}},
+		{5, []uint64{3, 4, 5}},
+	}
+	for _, step := range steps {
+		n.addVersion(n.ID(), "a.txt", fileVersion{step.version, "sum"}, 0)
+		if got := versionNumbers(n, "a.txt"); !reflect.DeepEqual(got, step.versions) {
+			t.Fatalf("versions %v after recording %d, want %v", got, step.version, step.versions)
+		}
+	}
+
+	// The replication of a put recorded after a newer one is not applied
+	n.addVersion(n.ID(), "a.txt", fileVersion{7, "sum"}, 1)
+	n.addVersion(n.ID(), "a.txt", fileVersion{6, "sum"}, 2)
+	if info, _ := n.Stat("a.txt"); info.Replication != 1 {
+		t.Errorf("replication %d, want 1 set by the newest version", info.Replication)
+	}
+}
@@ -0,0 +1,640 @@
+package fs513
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+/*
+ * Wire protocol. Every msg, datagram or stream, is one frame. All integers are big endian.
+ *
+ *   offset  size  field
+ *   0       4     magic "F513"
+ *   4       1     version, WIRE_VERSION of the sender
+ *   5       1     type, one of the MSG_* constants
+ *   6       8     request ID: probe sequence number of SYN, ACK and PingReq, matches a reply to its request
+ *   14      8     timestamp, unix nanoseconds at the sender
+ *   22      8     nonce, random per frame
+ *   30      4     payload length n
+ *   34      n     payload, layout depends on the type
+ *   34+n    1     MAC length m, 0 when the cluster runs without keys
+ *   35+n    m     HMAC-SHA256 over bytes 0 to 34+n with the cluster key
+ *
+ * Payload fields are written in the listed order. str is a uint16 length followed by the bytes, text a
+ * uint32 length followed by the bytes, list is
+ * a uint32 count followed by the elements, map is a uint32 count followed by key value pairs. The msgs
+ * whose fields start with host str carry from str, the member sending the msg, in front of it.
+ *
+ *   SYN, ACK                   host str, updates list of update
+ *   PING_REQ                   host str, target str, updates list of update
+ *   FAILED, LEAVE              host str, incarnation uint64, updates list of update
+ *   DEL_FILE, RM_FILE,
+ *   REPLICATE_FILE,
+ *   BAD_REPLICA                host str, file str
+ *   ELECTION, ANSWER,
+ *   COORDINATOR                host str
+ *   FILE_LIST                  version uint64, files map of str to file
+ *   GATEWAY_REQUEST            kind uint8, then for 1 join: cluster str, version uint8, min version uint8, member
+ *                                           for 2 handoff: host str, files map of str to str
+ *                                           for 3 version: host str, file str, replication uint8
+ *                                           for 4 replication: file str, replication uint8
+ *                                           for 5 commit: host str, file str, version uint64, checksum str,
+ *                                                         replication uint8
+ *   GATEWAY_RESPONSE           error str, redirect str, has join uint8, then if 1: leader str, members list of member,
+ *                              has version uint8, then if 1: version uint64, replicas list of str,
+ *                              replication uint8
+ *   FILE_HEADER                file str, size uint64, checksum str, version uint64, then on the stream size
+ *                              bytes of content outside of any frame
+ *   FILE_REQUEST               file str, version uint64, 0 for the header of the newest version held
+ *   FILE_RESULT                error str
+ *   GREP_REQUEST               args list of str
+ *   GREP_RESULT                result text, error str
+ *
+ *   update                     host str, status str, incarnation uint64, meta map of str to str
+ *   member                     host str, incarnation uint64, state str, meta map of str to str
+ *   file                       replicas list of str, replication uint8, versions list of version
+ *   version                    version uint64, checksum str
+ *
+ * A new version only appends fields to the end of a payload, or adds types and gateway requests. A
+ * receiver reads frames of every version from MIN_WIRE_VERSION on with the layouts it knows: it skips the
+ * fields a newer sender appended, and reads a field an older sender does not send yet only if the payload
+ * goes on, see more. Nodes of neighbouring versions therefore work together and a cluster is upgraded one
+ * node at a time. MIN_WIRE_VERSION is raised only by a change that cannot be made by appending, after
+ * which every node older than it has to be upgraded first. Frames below MIN_WIRE_VERSION or of unknown
+ * types are rejected and counted.
+ */
+const (
+	WIRE_MAGIC        = "F513"
+	WIRE_VERSION      = 1
+	MIN_WIRE_VERSION  = 1 // Oldest version read, and written on every join so the gateway can check it
+	FRAME_HEADER_LEN  = 34
+	MAX_FRAME_PAYLOAD = 16 << 20 // Largest payload accepted on a stream, file contents go outside of frames
+)
+
+type msgType uint8
+
+const (
+	MSG_SYN msgType = iota + 1
+	MSG_ACK
+	MSG_PING_REQ
+	MSG_FAILED
+	MSG_LEAVE
+	MSG_DEL_FILE
+	MSG_RM_FILE
+	MSG_REPLICATE_FILE
+	MSG_ELECTION
+	MSG_ANSWER
+	MSG_COORDINATOR
+	MSG_FILE_LIST
+	MSG_GATEWAY_REQUEST
+	MSG_GATEWAY_RESPONSE
+	MSG_FILE_HEADER
+	MSG_FILE_REQUEST
+	MSG_FILE_RESULT
+	MSG_BAD_REPLICA
+	MSG_GREP_REQUEST
+	MSG_GREP_RESULT
+)
+
+var msgNames = map[msgType]string{
+	MSG_SYN:              "SYN",
+	MSG_ACK:              "ACK",
+	MSG_PING_REQ:         "PingReq",
+	MSG_FAILED:           "Failed",
+	MSG_LEAVE:            "Leave",
+	MSG_DEL_FILE:         "DelFile",
+	MSG_RM_FILE:          "rmfile",
+	MSG_REPLICATE_FILE:   "replicateFile",
+	MSG_ELECTION:         "Election",
+	MSG_ANSWER:           "Answer",
+	MSG_COORDINATOR:      "Coordinator",
+	MSG_FILE_LIST:        "FileList",
+	MSG_GATEWAY_REQUEST:  "GatewayRequest",
+	MSG_GATEWAY_RESPONSE: "GatewayResponse",
+	MSG_FILE_HEADER:      "FileHeader",
+	MSG_FILE_REQUEST:     "FileRequest",
+	MSG_FILE_RESULT:      "FileResult",
+	MSG_BAD_REPLICA:      "BadReplica",
+	MSG_GREP_REQUEST:     "GrepRequest",
+	MSG_GREP_RESULT:      "GrepResult",
+}
+
+func (t msgType) String() string {
+	if name, ok := msgNames[t]; ok {
+		return name
+	}
+	return "Unknown(" + strconv.Itoa(int(t)) + ")"
+}
+
+var (
+	errBadMagic       = errors.New("not an fs513 frame")
+	errWireVersion    = errors.New("unsupported wire version")
+	errUnknownType    = errors.New("unknown message type")
+	errUnexpectedType = errors.New("unexpected message type")
+	errShortFrame     = errors.New("truncated frame")
+	errTooLong        = errors.New("field too long")
+)
+
+type frame struct {
+	Version   uint8
+	Type      msgType
+	RequestID uint64
+	Timestamp int64
+	Nonce     uint64
+	Payload   []byte
+	MAC       []byte
+	signed    []byte // Bytes covered by the MAC
+}
+
+func encodeHeader(t msgType, id uint64, ts int64, nonce uint64, payload []byte) []byte {
+	buf := make([]byte, FRAME_HEADER_LEN, FRAME_HEADER_LEN+len(payload)+1+32)
+	copy(buf, WIRE_MAGIC)
+	buf[4] = WIRE_VERSION
+	buf[5] = uint8(t)
+	binary.BigEndian.PutUint64(buf[6:], id)
+	binary.BigEndian.PutUint64(buf[14:], uint64(ts))
+	binary.BigEndian.PutUint64(buf[22:], nonce)
+	binary.BigEndian.PutUint32(buf[30:], uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+/*
+ * Parse a complete frame. The MAC is checked by the authenticator
+ */
+func decodeFrame(data []byte) (frame, error) {
+	f := frame{}
+	if len(data) < FRAME_HEADER_LEN+1 {
+		return f, errShortFrame
+	}
+	if string(data[:4]) != WIRE_MAGIC {
+		return f, errBadMagic
+	}
+	f.Version = data[4]
+	if f.Version < MIN_WIRE_VERSION {
+		return f, errWireVersion
+	}
+	f.Type = msgType(data[5])
+	if _, ok := msgNames[f.Type]; !ok {
+		return f, errUnknownType
+	}
+	f.RequestID = binary.BigEndian.Uint64(data[6:])
+	f.Timestamp = int64(binary.BigEndian.Uint64(data[14:]))
+	f.Nonce = binary.BigEndian.Uint64(data[22:])
+	length := int(binary.BigEndian.Uint32(data[30:]))
+	end := FRAME_HEADER_LEN + length
+	if length > len(data) || end+1 > len(data) {
+		return f, errShortFrame
+	}
+	f.Payload = data[FRAME_HEADER_LEN:end]
+	f.signed = data[:end]
+	macLen := int(data[end])
+	if end+1+macLen != len(data) {
+		return f, errShortFrame
+	}
+	f.MAC = data[end+1:]
+	return f, nil
+}
+
+/*
+ * Read exactly one frame from a stream. The payload is allocated before the MAC can be checked, so any
+ * peer can make it allocate up to MAX_FRAME_PAYLOAD
+ */
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, FRAME_HEADER_LEN)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != WIRE_MAGIC {
+		return nil, errBadMagic
+	}
+	length := binary.BigEndian.Uint32(header[30:])
+	if length > MAX_FRAME_PAYLOAD {
+		return nil, errTooLong
+	}
+	data := make([]byte, FRAME_HEADER_LEN+int(length)+1)
+	copy(data, header)
+	if _, err := io.ReadFull(r, data[FRAME_HEADER_LEN:]); err != nil {
+		return nil, err
+	}
+	mac := make([]byte, data[len(data)-1])
+	if _, err := io.ReadFull(r, mac); err != nil {
+		return nil, err
+	}
+	return append(data, mac...), nil
+}
+
+/*
+ * Encode v, a message, fileList, gatewayRequest, gatewayResponse, one of the file transfer or grep types, into
+ * its frame type and payload
+ */
+func marshal(v interface{}) (msgType, []byte, error) {
+	w := &wireWriter{}
+	var t msgType
+	switch m := v.(type) {
+	case message:
+		t = m.Type
+		w.str(m.From)
+		w.str(m.Host)
+		switch m.Type {
+		case MSG_SYN, MSG_ACK:
+			w.updates(m.Updates)
+		case MSG_PING_REQ:
+			w.str(m.Target)
+			w.updates(m.Updates)
+		case MSG_FAILED, MSG_LEAVE:
+			w.u64(m.Incarnation)
+			w.updates(m.Updates)
+		case MSG_DEL_FILE, MSG_RM_FILE, MSG_REPLICATE_FILE, MSG_BAD_REPLICA:
+			w.str(m.FS513Name)
+		case MSG_ELECTION, MSG_ANSWER, MSG_COORDINATOR:
+		default:
+			return 0, nil, fmt.Errorf("%v: %v", errUnknownType, m.Type)
+		}
+	case fileList:
+		t = MSG_FILE_LIST
+		w.u64(m.Version)
+		w.u32(len(m.Files))
+		for name, meta := range m.Files {
+			w.str(name)
+			w.strs(meta.Replicas)
+			w.u8(uint8(meta.Replication))
+			w.u32(len(meta.Versions))
+			for _, v := range meta.Versions {
+				w.u64(v.Version)
+				w.str(v.Checksum)
+			}
+		}
+	case gatewayRequest:
+		t = MSG_GATEWAY_REQUEST
+		switch {
+		case m.Join != nil:
+			w.u8(1)
+			w.str(m.Join.ClusterName)
+			w.u8(uint8(m.Join.Version))
+			w.u8(uint8(m.Join.MinVersion))
+			w.member(m.Join.Member)
+		case m.Handoff != nil:
+			w.u8(2)
+			w.str(m.Handoff.Host)
+			w.strMap(m.Handoff.Files)
+		case m.Version != nil:
+			w.u8(3)
+			w.str(m.Version.Host)
+			w.str(m.Version.Name)
+			w.u8(uint8(m.Version.Replication))
+		case m.Replication != nil:
+			w.u8(4)
+			w.str(m.Replication.Name)
+			w.u8(uint8(m.Replication.Replication))
+		case m.Commit != nil:
+			w.u8(5)
+			w.str(m.Commit.Host)
+			w.str(m.Commit.Name)
+			w.u64(m.Commit.Version)
+			w.str(m.Commit.Checksum)
+			w.u8(uint8(m.Commit.Replication))
+		default:
+			return 0, nil, errors.New("empty gateway request")
+		}
+	case gatewayResponse:
+		t = MSG_GATEWAY_RESPONSE
+		w.str(m.Error)
+		w.str(m.Redirect)
+		if m.Join == nil {
+			w.u8(0)
+		} else {
+			w.u8(1)
+			w.str(m.Join.Leader)
+			w.u32(len(m.Join.Members))
+			for _, member := range m.Join.Members {
+				w.member(member)
+			}
+		}
+		if m.Version == nil {
+			w.u8(0)
+		} else {
+			w.u8(1)
+			w.u64(m.Version.Version)
+			w.strs(m.Version.Replicas)
+			w.u8(uint8(m.Version.Replication))
+		}
+	case fileHeader:
+		t = MSG_FILE_HEADER
+		w.str(m.Name)
+		w.u64(m.Size)
+		w.str(m.Checksum)
+		w.u64(m.Version)
+	case fileRequest:
+		t = MSG_FILE_REQUEST
+		w.str(m.Name)
+		w.u64(m.Version)
+	case fileResult:
+		t = MSG_FILE_RESULT
+		w.str(m.Error)
+	case grepQuery:
+		t = MSG_GREP_REQUEST
+		w.strs(m.Args)
+	case grepAnswer:
+		t = MSG_GREP_RESULT
+		w.text(m.Result)
+		w.str(m.Error)
+	default:
+		return 0, nil, fmt.Errorf("cannot marshal %T", v)
+	}
+	return t, w.buf.Bytes(), w.err
+}
+
+/*
+ * Decode the payload of a frame of type t into v, a pointer to the type matching t
+ */
+func unmarshal(t msgType, id uint64, payload []byte, v interface{}) error {
+	r := &wireReader{data: payload}
+	switch m := v.(type) {
+	case *message:
+		*m = message{Type: t, Seq: id, From: r.str()}
+		m.Host = r.str()
+		switch t {
+		case MSG_SYN, MSG_ACK:
+			m.Updates = r.updates()
+		case MSG_PING_REQ:
+			m.Target = r.str()
+			m.Updates = r.updates()
+		case MSG_FAILED, MSG_LEAVE:
+			m.Incarnation = r.u64()
+			m.Updates = r.updates()
+		case MSG_DEL_FILE, MSG_RM_FILE, MSG_REPLICATE_FILE, MSG_BAD_REPLICA:
+			m.FS513Name = r.str()
+		case MSG_ELECTION, MSG_ANSWER, MSG_COORDINATOR:
+		default:
+			return errUnexpectedType
+		}
+	case *fileList:
+		if t != MSG_FILE_LIST {
+			return errUnexpectedType
+		}
+		m.Version = r.u64()
+		count := r.count()
+		m.Files = make(map[string]fileMeta, count)
+		for i := 0; i < count; i++ {
+			name := r.str()
+			meta := fileMeta{Replicas: r.strs(), Replication: int(r.u8())}
+			versions := r.count()
+			for j := 0; j < versions; j++ {
+				meta.Versions = append(meta.Versions, fileVersion{r.u64(), r.str()})
+			}
+			m.Files[name] = meta
+		}
+	case *gatewayRequest:
+		if t != MSG_GATEWAY_REQUEST {
+			return errUnexpectedType
+		}
+		switch r.u8() {
+		case 1:
+			m.Join = &joinRequest{ClusterName: r.str(), Version: int(r.u8()), MinVersion: int(r.u8()), Member: r.member()}
+		case 2:
+			m.Handoff = &handoffRequest{Host: r.str(), Files: r.strMap()}
+		case 3:
+			m.Version = &versionRequest{Host: r.str(), Name: r.str(), Replication: int(r.u8())}
+		case 4:
+			m.Replication = &replicationRequest{Name: r.str(), Replication: int(r.u8())}
+		case 5:
+			m.Commit = &commitRequest{Host: r.str(), Name: r.str(), Version: r.u64(), Checksum: r.str(),
+				Replication: int(r.u8())}
+		default:
+			if r.err == nil {
+				return errors.New("unknown gateway request")
+			}
+		}
+	case *gatewayResponse:
+		if t != MSG_GATEWAY_RESPONSE {
+			return errUnexpectedType
+		}
+		m.Error = r.str()
+		m.Redirect = r.str()
+		if r.u8() == 1 {
+			m.Join = &joinResponse{Leader: r.str()}
+			count := r.count()
+			m.Join.Members = make([]Member, 0, count)
+			for i := 0; i < count; i++ {
+				m.Join.Members = append(m.Join.Members, r.member())
+			}
+		}
+		if r.u8() == 1 {
+			m.Version = &versionGrant{Version: r.u64(), Replicas: r.strs(), Replication: int(r.u8())}
+		}
+	case *fileHeader:
+		if t != MSG_FILE_HEADER {
+			return errUnexpectedType
+		}
+		*m = fileHeader{r.str(), r.u64(), r.str(), r.u64()}
+	case *fileRequest:
+		if t != MSG_FILE_REQUEST {
+			return errUnexpectedType
+		}
+		m.Name = r.str()
+		m.Version = r.u64()
+	case *fileResult:
+		if t != MSG_FILE_RESULT {
+			return errUnexpectedType
+		}
+		m.Error = r.str()
+	case *grepQuery:
+		if t != MSG_GREP_REQUEST {
+			return errUnexpectedType
+		}
+		m.Args = r.strs()
+	case *grepAnswer:
+		if t != MSG_GREP_RESULT {
+			return errUnexpectedType
+		}
+		m.Result = r.text()
+		m.Error = r.str()
+	default:
+		return fmt.Errorf("cannot unmarshal into %T", v)
+	}
+	// Bytes left are fields appended by a newer version
+	return r.err
+}
+
+type wireWriter struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (w *wireWriter) u8(v uint8) {
+	w.buf.WriteByte(v)
+}
+
+func (w *wireWriter) u32(v int) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	w.buf.Write(b[:])
+}
+
+func (w *wireWriter) u64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *wireWriter) str(s string) {
+	if len(s) > 0xffff {
+		w.err = errTooLong
+		return
+	}
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(len(s)))
+	w.buf.Write(b[:])
+	w.buf.WriteString(s)
+}
+
+func (w *wireWriter) text(s string) {
+	w.u32(len(s))
+	w.buf.WriteString(s)
+}
+
+func (w *wireWriter) strs(list []string) {
+	w.u32(len(list))
+	for _, s := range list {
+		w.str(s)
+	}
+}
+
+func (w *wireWriter) strMap(m map[string]string) {
+	w.u32(len(m))
+	for k, v := range m {
+		w.str(k)
+		w.str(v)
+	}
+}
+
+func (w *wireWriter) updates(list []update) {
+	w.u32(len(list))
+	for _, u := range list {
+		w.str(u.Host)
+		w.str(u.Status)
+		w.u64(u.Incarnation)
+		w.strMap(u.Meta)
+	}
+}
+
+func (w *wireWriter) member(m Member) {
+	w.str(m.Host)
+	w.u64(m.Incarnation)
+	w.str(m.State)
+	w.strMap(m.Meta)
+}
+
+/*
+ * wireReader consumes a payload. The first error sticks and every later read returns a zero value
+ */
+type wireReader struct {
+	data []byte
+	err  error
+}
+
+func (r *wireReader) take(size int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if size > len(r.data) {
+		r.err = errShortFrame
+		return nil
+	}
+	b := r.data[:size]
+	r.data = r.data[size:]
+	return b
+}
+
+/*
+ * Whether the payload goes on. A field appended by a version after the first one that has fields of
+ * its type is read only if it does, and left at its zero value in frames of older senders
+ */
+func (r *wireReader) more() bool {
+	return r.err == nil && len(r.data) > 0
+}
+
+func (r *wireReader) u8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *wireReader) u64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+/*
+ * Element count of a list or map. Every element takes at least one byte, which bounds the allocation
+ */
+func (r *wireReader) count() int {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	c := int(binary.BigEndian.Uint32(b))
+	if c > len(r.data) {
+		r.err = errShortFrame
+		return 0
+	}
+	return c
+}
+
+func (r *wireReader) str() string {
+	b := r.take(2)
+	if b == nil {
+		return ""
+	}
+	return string(r.take(int(binary.BigEndian.Uint16(b))))
+}
+
+func (r *wireReader) text() string {
+	b := r.take(4)
+	if b == nil {
+		return ""
+	}
+	return string(r.take(int(binary.BigEndian.Uint32(b))))
+}
+
+func (r *wireReader) strs() []string {
+	count := r.count()
+	list := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		list = append(list, r.str())
+	}
+	return list
+}
+
+func (r *wireReader) strMap() map[string]string {
+	count := r.count()
+	if count == 0 {
+		return nil
+	}
+	m := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		k := r.str()
+		m[k] = r.str()
+	}
+	return m
+}
+
+func (r *wireReader) updates() []update {
+	count := r.count()
+	list := make([]update, 0, count)
+	for i := 0; i < count; i++ {
+		list = append(list, update{r.str(), r.str(), r.u64(), r.strMap()})
+	}
+	return list
+}
+
+func (r *wireReader) member() Member {
+	return Member{r.str(), r.u64(), r.str(), r.strMap()}
+}
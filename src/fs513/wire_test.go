@@ -0,0 +1,209 @@
+package fs513
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+	"transport"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	updates := []update{
+		{"10.0.0.1:6000", ALIVE, 7, map[string]string{"zone": "a"}},
+		{"10.0.0.2:6000", SUSPECT, 8, nil}, // An empty map is decoded as nil
+	}
+	member := Member{"10.0.0.3:6000", 9, ALIVE, map[string]string{"role": "storage"}}
+	values := []interface{}{
+		message{From: "10.0.0.1:6000", Host: "10.0.0.1:6000", Type: MSG_SYN, Seq: 42, Updates: updates},
+		message{From: "10.0.0.1:6000", Host: "10.0.0.1:6000", Type: MSG_PING_REQ, Seq: 43, Target: "10.0.0.2:6000",
+			Updates: updates},
+		message{From: "10.0.0.1:6000", Host: "10.0.0.2:6000", Type: MSG_FAILED, Incarnation: 8, Updates: updates},
+		message{From: "10.0.0.2:6000", Host: "10.0.0.3:6000", Type: MSG_DEL_FILE, FS513Name: "a.txt"},
+		message{From: "10.0.0.1:6000", Host: "10.0.0.4:6000", Type: MSG_REPLICATE_FILE, FS513Name: "a.txt"},
+		message{From: "10.0.0.4:6000", Host: "10.0.0.4:6000", Type: MSG_COORDINATOR},
+		fileList{5, map[string]fileMeta{
+			"a.txt": {[]string{"10.0.0.1:6000", "10.0.0.2:6000"}, 2, []fileVersion{{1, "abc"}, {2, "def"}}},
+		}},
+		gatewayRequest{Join: &joinRequest{"fs513", WIRE_VERSION, MIN_WIRE_VERSION, member}},
+		gatewayRequest{Version: &versionRequest{"10.0.0.1:6000", "a.txt", 3}},
+		gatewayRequest{Replication: &replicationRequest{"a.txt", 1}},
+		gatewayRequest{Commit: &commitRequest{"10.0.0.2:6000", "a.txt", 4, "abc", 2}},
+		gatewayResponse{Join: &joinResponse{"10.0.0.4:6000", []Member{member}}},
+		gatewayResponse{Version: &versionGrant{4, []string{"10.0.0.1:6000"}, 1}},
+		gatewayResponse{Error: "rejected", Redirect: "10.0.0.4:6000"},
+		fileHeader{"a.txt", 12, "abc", 4},
+		fileRequest{"a.txt", 0},
+		fileResult{"no such file"},
+		grepQuery{[]string{"-i", "--", "error"}},
+		grepAnswer{"Results from host [10.0.0.1:6000]\nerror\n", ""},
+	}
+	for _, v := range values {
+		typ, payload, err := marshal(v)
+		if err != nil {
+			t.Fatalf("marshal %#v: %v", v, err)
+		}
+		var id uint64
+		if m, ok := v.(message); ok {
+			id = m.Seq
+		}
+		decoded := reflect.New(reflect.TypeOf(v))
+		if err := unmarshal(typ, id, payload, decoded.Interface()); err != nil {
+			t.Fatalf("unmarshal %#v: %v", v, err)
+		}
+		if got := decoded.Elem().Interface(); !reflect.DeepEqual(got, v) {
+			t.Errorf("round trip of %s changed\n%#v\nto\n%#v", typ, v, got)
+		}
+	}
+}
+
+func TestUnmarshalRejectsTruncatedPayload(t *testing.T) {
+	typ, payload, err := marshal(message{Host: "10.0.0.1:6000", Type: MSG_DEL_FILE, FS513Name: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unmarshal(typ, 0, payload[:len(payload)-2], &message{}); err == nil {
+		t.Error("truncated payload was accepted")
+	}
+}
+
+/*
+ * Fields appended by a newer version are skipped, so older nodes keep reading its frames
+ */
+func TestUnmarshalSkipsAppendedFields(t *testing.T) {
+	m := message{From: "10.0.0.1:6000", Host: "10.0.0.2:6000", Type: MSG_RM_FILE, FS513Name: "a.txt"}
+	typ, payload, err := marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := message{}
+	if err := unmarshal(typ, 0, append(payload, 0, 7, 'a', 'p', 'p', 'e', 'n', 'd', 's'), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Errorf("decoded %#v, want %#v", decoded, m)
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	sender := newAuthenticator([]string{"fs513-test-cluster-key"}, time.Minute)
+	receiver := newAuthenticator([]string{"fs513-test-cluster-key"}, time.Minute)
+	data, err := sender.seal(MSG_SYN, 7, []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := receiver.open(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != MSG_SYN || f.RequestID != 7 || string(f.Payload) != "payload" {
+		t.Errorf("opened %s %d %q, want SYN 7 \"payload\"", f.Type, f.RequestID, f.Payload)
+	}
+	if _, err := receiver.open(data); err != errReplayed {
+		t.Errorf("second open: %v, want %v", err, errReplayed)
+	}
+
+	tampered, _ := sender.seal(MSG_SYN, 7, []byte("payload"))
+	tampered[FRAME_HEADER_LEN] ^= 1
+	if _, err := receiver.open(tampered); err != errBadMAC {
+		t.Errorf("tampered frame: %v, want %v", err, errBadMAC)
+	}
+
+	other := newAuthenticator([]string{"another-cluster-key-entirely"}, time.Minute)
+	foreign, _ := other.seal(MSG_SYN, 7, []byte("payload"))
+	if _, err := receiver.open(foreign); err != errBadMAC {
+		t.Errorf("frame of another cluster: %v, want %v", err, errBadMAC)
+	}
+	if rejected := receiver.rejectedCount(); rejected != 3 {
+		t.Errorf("%d frames counted as rejected, want 3", rejected)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := newAuthenticator([]string{"old-cluster-key-old-key"}, time.Minute)
+	rotating := newAuthenticator([]string{"new-cluster-key-new-key", "old-cluster-key-old-key"}, time.Minute)
+
+	fromOld, _ := old.seal(MSG_ACK, 1, nil)
+	if _, err := rotating.open(fromOld); err != nil {
+		t.Errorf("frame signed with the old key: %v", err)
+	}
+	fromRotating, _ := rotating.seal(MSG_ACK, 1, nil)
+	if _, err := old.open(fromRotating); err != errBadMAC {
+		t.Errorf("frame signed with the new key by a node without it: %v, want %v", err, errBadMAC)
+	}
+
+	old.setKeys([]string{"old-cluster-key-old-key", "new-cluster-key-new-key"})
+	if _, err := old.open(fromRotating); err != nil {
+		t.Errorf("frame signed with the new key after adding it: %v", err)
+	}
+}
+
+func TestOpenChecksVersionsAndAge(t *testing.T) {
+	a := newAuthenticator(nil, time.Minute)
+
+	older, _ := a.seal(MSG_SYN, 1, nil)
+	older[4] = MIN_WIRE_VERSION - 1
+	if _, err := a.open(older); err != errWireVersion {
+		t.Errorf("frame of version %d: %v, want %v", MIN_WIRE_VERSION-1, err, errWireVersion)
+	}
+	newer, _ := a.seal(MSG_SYN, 2, nil)
+	newer[4] = WIRE_VERSION + 1
+	if _, err := a.open(newer); err != nil {
+		t.Errorf("frame of the next version %d: %v", WIRE_VERSION+1, err)
+	}
+
+	old := encodeHeader(MSG_SYN, 1, time.Now().Add(-2*time.Minute).UnixNano(), 1, nil)
+	if _, err := a.open(append(old, 0)); err != errExpired {
+		t.Errorf("frame older than the replay window: %v, want %v", err, errExpired)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	a := newAuthenticator([]string{"fs513-test-cluster-key"}, time.Minute)
+	data, _ := a.seal(MSG_FILE_LIST, 3, []byte("list"))
+	stream := bytes.NewReader(append(append([]byte(nil), data...), data...))
+	for i := 0; i < 2; i++ {
+		read, err := readFrame(stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, data) {
+			t.Fatalf("frame %d read as %x, want %x", i, read, data)
+		}
+	}
+
+	// The length is checked before anything is allocated for the payload
+	header := encodeHeader(MSG_FILE_LIST, 3, time.Now().UnixNano(), 1, nil)
+	binary.BigEndian.PutUint32(header[30:], MAX_FRAME_PAYLOAD+1)
+	if _, err := readFrame(bytes.NewReader(header)); err != errTooLong {
+		t.Errorf("oversized frame: %v, want %v", err, errTooLong)
+	}
+}
+
+/*
+ * A node joins as long as each side reads the version the other one speaks
+ */
+func TestJoinChecksProtocolVersions(t *testing.T) {
+	network := transport.NewNetwork()
+	leader := startTestNode(t, network, 0, 2, nil)
+	joiner := Member{testID(1), 1, ALIVE, nil}
+	cases := []struct {
+		version, min int
+		accepted     bool
+	}{
+		{WIRE_VERSION, MIN_WIRE_VERSION, true},
+		{WIRE_VERSION + 1, MIN_WIRE_VERSION, true},
+		{MIN_WIRE_VERSION - 1, MIN_WIRE_VERSION - 1, false},
+		{WIRE_VERSION + 2, WIRE_VERSION + 1, false},
+	}
+	for _, c := range cases {
+		joiner.Incarnation++
+		resp := leader.handleJoin(&joinRequest{leader.conf.ClusterName, c.version, c.min, joiner})
+		if accepted := resp.Error == ""; accepted != c.accepted {
+			t.Errorf("join speaking %d and reading from %d accepted %v, want %v (%s)", c.version, c.min, accepted,
+				c.accepted, resp.Error)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package fs513client
+
+import (
+	"bytes"
+	"config"
+	"context"
+	"encoding/json"
+	"fmt"
+	"fs513"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Client of the admin API of a running fs513 node, see fs513/admin.go for the endpoints
+ */
+const (
+	DEFAULT_TIMEOUT = time.Minute    // Fetch and grep wait on other members, so allow for slow replies
+	SOCKET_ENV      = "FS513_SOCKET" // Environment variable holding the path of the control socket
+)
+
+type Client struct {
+	base string
+	http *http.Client
+}
+
+/*
+ * APIError is a request the node answered with an error status
+ */
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+/*
+ * New returns a client of the admin API at addr, host:port
+ */
+func New(addr string) *Client {
+	return &Client{"http://" + addr, &http.Client{Timeout: DEFAULT_TIMEOUT}}
+}
+
+/*
+ * NewUnix returns a client of the admin API served on the control socket at path
+ */
+func NewUnix(path string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	// The host is ignored, every request goes to the socket
+	return &Client{"http://fs513", &http.Client{Transport: transport, Timeout: DEFAULT_TIMEOUT}}
+}
+
+/*
+ * DefaultSocket is the control socket named by SOCKET_ENV, or else the one of a node on the default port
+ */
+func DefaultSocket() string {
+	if path := os.Getenv(SOCKET_ENV); path != "" {
+		return path
+	}
+	return strings.Replace(config.Default().ControlSocket, config.PORT_VAR, strconv.Itoa(config.DEFAULT_PORT), -1)
+}
+
+func (c *Client) Self() (fs513.SelfInfo, error) {
+	var v fs513.SelfInfo
+	return v, c.do(http.MethodGet, "/v1/self", nil, &v)
+}
+
+func (c *Client) Members() ([]fs513.Member, error) {
+	var v []fs513.Member
+	return v, c.do(http.MethodGet, "/v1/members", nil, &v)
+}
+
+/*
+ * Files lists the files whose name starts with prefix, every file for an empty prefix
+ */
+func (c *Client) Files(prefix string) ([]fs513.FileInfo, error) {
+	var v []fs513.FileInfo
+	return v, c.do(http.MethodGet, "/v1/files?prefix="+url.QueryEscape(prefix), nil, &v)
+}
+
+func (c *Client) Stat(name string) (fs513.FileInfo, error) {
+	var v fs513.FileInfo
+	return v, c.do(http.MethodGet, filePath(name), nil, &v)
+}
+
+/*
+ * Put stores the file at localPath, a path on the host of the node, under name. If name exists the file
+ * becomes its next version. replication sets the replicas kept of the file, 0 keeps the current or the
+ * default one
+ */
+func (c *Client) Put(localPath string, name string, replication int) (fs513.FileInfo, error) {
+	var v fs513.FileInfo
+	body := map[string]interface{}{"local_path": localPath, "replication": replication}
+	return v, c.do(http.MethodPut, filePath(name), body, &v)
+}
+
+/*
+ * SetReplication changes the replicas kept of name
+ */
+func (c *Client) SetReplication(name string, replication int) (fs513.FileInfo, error) {
+	var v fs513.FileInfo
+	return v, c.do(http.MethodPatch, filePath(name), map[string]int{"replication": replication}, &v)
+}
+
+/*
+ * Get copies name from one of its replicas into the storage directory of the node
+ */
+func (c *Client) Get(name string) (fs513.FileInfo, error) {
+	var v fs513.FileInfo
+	return v, c.do(http.MethodPost, "/v1/fetch", map[string]string{"name": name}, &v)
+}
+
+/*
+ * Fetch copies name to dest, a path on the host of the node
+ */
+func (c *Client) Fetch(name string, dest string) (fs513.FileInfo, error) {
+	var v fs513.FileInfo
+	return v, c.do(http.MethodPost, "/v1/fetch", map[string]string{"name": name, "dest": dest}, &v)
+}
+
+/*
+ * FetchVersions writes the last k versions of name to dest, newest first, each preceded by a delimiter line
+ */
+func (c *Client) FetchVersions(name string, k int, dest string) (fs513.FileInfo, error) {
+	var v fs513.FileInfo
+	body := map[string]interface{}{"name": name, "dest": dest, "versions": k}
+	return v, c.do(http.MethodPost, "/v1/fetch", body, &v)
+}
+
+func (c *Client) Remove(name string) (fs513.FileInfo, error) {
+	var v fs513.FileInfo
+	return v, c.do(http.MethodDelete, filePath(name), nil, &v)
+}
+
+/*
+ * Grep returns the results of every member. Results are returned together with the error when some
+ * members did not answer
+ */
+func (c *Client) Grep(args []string) (fs513.GrepResult, error) {
+	var v fs513.GrepResult
+	err := c.do(http.MethodPost, "/v1/grep", map[string][]string{"args": args}, &v)
+	return v, err
+}
+
+func (c *Client) LocalFiles() ([]string, error) {
+	var v []string
+	return v, c.do(http.MethodGet, "/v1/local", nil, &v)
+}
+
+func (c *Client) Metrics() (fs513.Metrics, error) {
+	var v fs513.Metrics
+	return v, c.do(http.MethodGet, "/v1/metrics", nil, &v)
+}
+
+func (c *Client) Join() (fs513.SelfInfo, error) {
+	var v fs513.SelfInfo
+	return v, c.do(http.MethodPost, "/v1/join", nil, &v)
+}
+
+func (c *Client) Leave() (fs513.SelfInfo, error) {
+	var v fs513.SelfInfo
+	return v, c.do(http.MethodPost, "/v1/leave", nil, &v)
+}
+
+func filePath(name string) string {
+	return "/v1/files/" + (&url.URL{Path: name}).EscapedPath()
+}
+
+/*
+ * Send body as JSON and decode the reply into v. An error status is returned as *APIError, after
+ * decoding the reply into v as well since some errors come with partial results
+ */
+func (c *Client) do(method string, path string, body interface{}, v interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		json.Unmarshal(data, v)
+		e := struct {
+			Error string `json:"error"`
+		}{}
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = fmt.Sprintf("%s %s: %s", method, path, resp.Status)
+		}
+		return &APIError{resp.StatusCode, e.Error}
+	}
+	return json.Unmarshal(data, v)
+}
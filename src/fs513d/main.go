@@ -0,0 +1,73 @@
+package main
+
+import (
+	"config"
+	"context"
+	"fmt"
+	"fs513"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/*
+ * fs513d runs a node headless. It is controlled over the control socket, by groupmain or the fs513
+ * command, and leaves the group on SIGINT or SIGTERM so that it can run under a service manager. The
+ * introducer joins on its own once started, every other node is told to join. SIGHUP loads the config
+ * again and applies its cluster_keys, to rotate keys without a restart.
+ */
+func main() {
+
+	conf, err := config.Load(os.Args[0], os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if conf.ControlSocket == "" && conf.AdminAddr == "" {
+		fmt.Println("Neither control_socket nor admin_addr is set, the node could not be controlled")
+		os.Exit(2)
+	}
+
+	node, err := fs513.New(conf)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	// Registered before Start so that a signal arriving while starting is not lost
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	if err := node.Start(context.Background()); err != nil {
+		fmt.Println("Not able to start node:", err)
+		os.Exit(1)
+	}
+	if conf.ControlSocket != "" {
+		fmt.Println("Node " + node.ID() + " started, control socket " + conf.ControlSocket)
+	} else {
+		fmt.Println("Node " + node.ID() + " started, admin API on " + conf.AdminOn())
+	}
+	if node.ID() == conf.Introducer {
+		if err := node.Join(); err != nil {
+			fmt.Println("Not able to join the group:", err)
+		}
+	}
+
+	sig := <-signals
+	for sig == syscall.SIGHUP {
+		if reloaded, err := config.Load(os.Args[0], os.Args[1:]); err != nil {
+			fmt.Println("Not able to reload the config:", err)
+		} else {
+			node.SetClusterKeys(reloaded.ClusterKeys)
+			fmt.Println("Reloaded cluster_keys")
+		}
+		sig = <-signals
+	}
+	fmt.Println("Received " + sig.String() + ", stopping")
+	if node.State() == fs513.STATE_ACTIVE {
+		if err := node.Leave(); err != nil {
+			fmt.Println("Not able to leave the group:", err)
+		}
+	}
+	node.Stop()
+}
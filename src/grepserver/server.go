@@ -1,72 +1,57 @@
 package grepserver
 
 import (
-	"bytes"
-	"encoding/gob"
-	"fmt"
-	"net"
-	"os"
+	"errors"
+	"strings"
 	"utils"
 )
 
-const (
-	BUF_LEN = 1024
-	PORT    = "8008"
-)
-
-var (
-	localIp string
-)
-
 /*
- *  Server to listen to grep requests from client
+ * Grep over the log of a node. Requests come from other nodes, so a request may only give a search
+ * pattern and flags out of GREP_FLAGS, and the pattern always follows "--": it can neither name other
+ * files to search nor be taken for a flag
  */
-func StartGrepServer() {
+const GREP_FLAGS = "icnvwxEFo" // Short grep flags a request may give, alone or combined as in -in
 
-	listener, err := net.Listen("tcp", ":"+PORT)
+var errArgs = errors.New("grep takes one pattern and flags out of -" + GREP_FLAGS)
 
-	if err != nil {
-		fmt.Println("error listening:", err.Error())
-		os.Exit(1)
-	}
-
-	localIp = utils.GetLocalIP()
-
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Println("Error accept:", err.Error())
-			return
+/*
+ * Check the arguments of a grep request and return those to run grep with, without the log file. Flags
+ * may come before or after the pattern, a pattern starting with - follows "--"
+ */
+func CommandLine(args []string) ([]string, error) {
+	flags := make([]string, 0)
+	patterns := make([]string, 0)
+	for i, arg := range args {
+		if arg == "--" {
+			patterns = append(patterns, args[i+1:]...)
+			break
 		}
-
-		grepLog(conn)
+		if len(arg) > 1 && arg[0] == '-' {
+			for _, c := range arg[1:] {
+				if !strings.ContainsRune(GREP_FLAGS, c) {
+					return nil, errArgs
+				}
+			}
+			flags = append(flags, arg)
+			continue
+		}
+		patterns = append(patterns, arg)
 	}
+	if len(patterns) != 1 {
+		return nil, errArgs
+	}
+	return append(flags, "--", patterns[0]), nil
 }
 
 /*
- * Receive the data from client and exec grep using the keyword
+ * Search runs grep with the arguments of a request on the log file at path. Results are labelled with
+ * the node name
  */
-func grepLog(conn net.Conn) {
-
-	recvBuf := make([]byte, BUF_LEN)
-	_, err := conn.Read(recvBuf)
-
+func Search(args []string, path string, name string) (string, error) {
+	cmdArgs, err := CommandLine(args)
 	if err != nil {
-		fmt.Println("Error reading:", err.Error())
-		return
+		return "", err
 	}
-
-	// convert bytes to string
-	strs := []string{}
-	gob.NewDecoder(bytes.NewReader(recvBuf)).Decode(&strs)
-
-	var results string
-	// exec the grep
-	results = utils.ExecGrep(strs, localIp)
-
-	// convert result to bytes and send back to client
-	sendBuf := make([]byte, len(results))
-	copy(sendBuf, string(results))
-	conn.Write(sendBuf)
-	conn.Close()
+	return utils.ExecGrep(cmdArgs, path, name), nil
 }
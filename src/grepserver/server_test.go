@@ -0,0 +1,35 @@
+package grepserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandLine(t *testing.T) {
+	cases := []struct {
+		args []string
+		want []string // nil if the request is refused
+	}{
+		{[]string{"error"}, []string{"--", "error"}},
+		{[]string{"-i", "error", "-n"}, []string{"-i", "-n", "--", "error"}},
+		{[]string{"-in", "--", "-v"}, []string{"-in", "--", "-v"}},
+		{[]string{"-r", "x", "/etc"}, nil},
+		{[]string{"x", "/etc/passwd"}, nil},
+		{[]string{"-f", "/etc/passwd"}, nil},
+		{[]string{"--include=*", "x"}, nil},
+		{[]string{"-i"}, nil},
+		{nil, nil},
+	}
+	for _, c := range cases {
+		got, err := CommandLine(c.args)
+		if c.want == nil {
+			if err == nil {
+				t.Errorf("%q accepted as %q", c.args, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q gives %q, %v, want %q", c.args, got, err, c.want)
+		}
+	}
+}
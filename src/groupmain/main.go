@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"fs513"
+	"fs513client"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Main function entry point. The node itself runs in fs513d, this is the menu driving it over its control
+ * socket
+ */
+func main() {
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	socket := fs.String("socket", fs513client.DefaultSocket(), "control socket of the node, or set "+fs513client.SOCKET_ENV)
+	addr := fs.String("addr", "", "admin address host:port of the node, used instead of the control socket")
+	fs.Parse(os.Args[1:])
+
+	client := fs513client.NewUnix(*socket)
+	if *addr != "" {
+		client = fs513client.New(*addr)
+	}
+	self, err := client.Self()
+	if err != nil {
+		fmt.Println("Not able to reach the node, is fs513d running?", err)
+		os.Exit(1)
+	}
+	fmt.Println("Connected to " + self.ID)
+
+	takeUserInput(client)
+}
+
+/*
+ * Take input from user from stdin and executes corresponding function
+ */
+func takeUserInput(client *fs513client.Client) {
+
+	reader := bufio.NewReader(os.Stdin)
+
+	for {
+		fmt.Println("1  - Print membership list")
+		fmt.Println("2  - Print self ID, state and leader")
+		fmt.Println("3  - Join group")
+		fmt.Println("4  - Leave group")
+		fmt.Println("5  - Grep node logs")
+		fmt.Println("********************* FS513 Options *****************************")
+		fmt.Println("6  - put [localfilename] [fs513filename] [replicas]")
+		fmt.Println("7  - get [fs513filename]")
+		fmt.Println("8  - remove [fs513filename]")
+		fmt.Println("9  - locate [fs513filename]")
+		fmt.Println("10 - list all fs513 files")
+		fmt.Println("11 - list all local files")
+		fmt.Println("12 - get-versions [fs513filename] [num-versions] [localfilename]")
+		fmt.Println("13 - setrep [fs513filename] [replicas]")
+		fmt.Println("********************* Metrics ***********************************")
+		fmt.Println("14 - print metrics")
+		fmt.Println("15 - export metrics as JSON [path]")
+		fmt.Println("Enter option: ")
+		input, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// stdin closed, the node keeps running in fs513d
+			return
+		}
+		input = strings.TrimSuffix(input, "\n")
+		switch input {
+		case "1":
+			members, err := client.Members()
+			for _, element := range members {
+				fmt.Println(element)
+			}
+			printError(err)
+		case "2":
+			self, err := client.Self()
+			if err == nil {
+				fmt.Println(self.ID + " incarnation " + strconv.FormatUint(self.Incarnation, 10) + " state " + string(self.State))
+				fmt.Println("Leader: " + self.Leader)
+			}
+			printError(err)
+		case "3":
+			fmt.Println("Joining group")
+			_, err := client.Join()
+			printError(err)
+		case "4":
+			fmt.Println("Leaving group TS - " + time.Now().Format(time.StampMicro))
+			if _, err := client.Leave(); err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Left the group, option 3 joins again")
+			}
+		case "5":
+			grepClient(client, reader)
+		case "6":
+			fmt.Println("Local path?")
+			local_path := readLine(reader)
+			fmt.Println("FS513 name?")
+			fs513_name := readLine(reader)
+			fmt.Println("Number of replicas? (empty for the default)")
+			replication := 0
+			if line := readLine(reader); line != "" {
+				if replication, err = strconv.Atoi(line); err != nil || replication < 1 {
+					fmt.Println("Invalid number of replicas")
+					break
+				}
+			}
+			fmt.Println("Add file Start..", time.Now().Format(time.StampMicro))
+			// The node resolves the path on this host, but not from this directory
+			if abs, err := filepath.Abs(local_path); err == nil {
+				local_path = abs
+			}
+			_, err := client.Put(local_path, fs513_name, replication)
+			printError(err)
+		case "7":
+			fmt.Println("FS513 name?")
+			fs513_name := readLine(reader)
+			fmt.Println("GetFile Start..", time.Now().Format(time.StampMicro))
+			_, err := client.Get(fs513_name)
+			printError(err)
+		case "8":
+			fmt.Println("FS513 name?")
+			fs513_name := readLine(reader)
+			fmt.Println("Remove File..", time.Now().Format(time.StampMicro))
+			_, err := client.Remove(fs513_name)
+			printError(err)
+		case "9":
+			fmt.Println("FS513 name?")
+			fs513_name := readLine(reader)
+			info, err := client.Stat(fs513_name)
+			if err == nil {
+				fmt.Println("Locate: "+fs513_name+" IPs: ", info.Replicas)
+			}
+			printError(err)
+		case "10":
+			//list all fs513 files
+			files, err := client.Files("")
+			for _, f := range files {
+				fmt.Println("FS513 File:"+f.Name+" IPs:", f.Replicas)
+			}
+			printError(err)
+		case "11":
+			files, err := client.LocalFiles()
+			if err == nil {
+				fmt.Println("Local files are ", files)
+			}
+			printError(err)
+		case "12":
+			fmt.Println("FS513 name?")
+			fs513_name := readLine(reader)
+			fmt.Println("Number of versions?")
+			num_versions, err := strconv.Atoi(readLine(reader))
+			if err != nil || num_versions < 1 {
+				fmt.Println("Invalid number of versions")
+				break
+			}
+			fmt.Println("Local path?")
+			local_path := readLine(reader)
+			if abs, err := filepath.Abs(local_path); err == nil {
+				local_path = abs
+			}
+			fmt.Println("GetVersions Start..", time.Now().Format(time.StampMicro))
+			_, err = client.FetchVersions(fs513_name, num_versions, local_path)
+			printError(err)
+		case "13":
+			fmt.Println("FS513 name?")
+			fs513_name := readLine(reader)
+			fmt.Println("Number of replicas?")
+			replication, err := strconv.Atoi(readLine(reader))
+			if err != nil {
+				fmt.Println("Invalid number of replicas")
+				break
+			}
+			_, err = client.SetReplication(fs513_name, replication)
+			printError(err)
+		case "14":
+			m, err := client.Metrics()
+			if err == nil {
+				printMetrics(m)
+			}
+			printError(err)
+		case "15":
+			fmt.Println("Path?")
+			path := readLine(reader)
+			m, err := client.Metrics()
+			if err == nil {
+				err = exportMetrics(m, path)
+			}
+			if err != nil {
+				fmt.Println("Not able to export metrics:", err)
+			} else {
+				fmt.Println("Metrics written to " + path)
+			}
+		default:
+			fmt.Println("Invalid command")
+		}
+		fmt.Print("\n\n\n")
+	}
+}
+
+/*
+ * Run grep on the servers currently in the membership list
+ */
+func grepClient(client *fs513client.Client, reader *bufio.Reader) {
+
+	fmt.Println("Usage: [-icnvwxEFo] keywordToSearch")
+	fmt.Println("Enter: ")
+	serverInput := strings.Fields(readLine(reader))
+	// Send data to every server in membershipList
+	tStart := time.Now()
+	res, err := client.Grep(serverInput)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, result := range res.Results {
+		fmt.Println(result)
+		fmt.Printf("END----------------------------------------------------------\n")
+	}
+	tEnd := time.Now()
+	fmt.Println("Grep results took ", tEnd.Sub(tStart))
+}
+
+func printError(err error) {
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+/*
+ * Print the counters of the node, durations in milliseconds
+ */
+func printMetrics(m fs513.Metrics) {
+	fmt.Println("Msgs sent:", m.MsgsSent)
+	fmt.Println("Msgs received:", m.MsgsReceived)
+	fmt.Println("Bytes sent by port:", m.BytesSent)
+	fmt.Println("Bytes received by port:", m.BytesReceived)
+	fmt.Println("Rejected frames:", m.Rejected)
+	fmt.Println("ACK RTT ms:", formatHistogram(m.AckRTT))
+	fmt.Println("Last ACK to Failed ms:", formatHistogram(m.DetectionTime))
+	fmt.Println("False positives:", m.FalsePositives)
+	for _, s := range m.MembershipSize {
+		fmt.Println("Membership size " + strconv.Itoa(s.Size) + " since " + s.Time.Format(time.StampMicro))
+	}
+	fmt.Printf("Transfers: %d ok, %d failed, %d bytes, %.0f bytes/s\n",
+		m.Transfers.Count, m.Transfers.Failed, m.Transfers.Bytes, m.Transfers.BytesPerSecond)
+}
+
+func formatHistogram(h fs513.Histogram) string {
+	if h.Count == 0 {
+		return "no samples"
+	}
+	return fmt.Sprintf("count %d avg %.2f min %.2f max %.2f", h.Count, h.Sum/float64(h.Count)*1000, h.Min*1000, h.Max*1000)
+}
+
+func exportMetrics(m fs513.Metrics, path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func readLine(reader *bufio.Reader) string {
+	line, _ := reader.ReadString('\n')
+	return strings.TrimRight(line, "\n")
+}
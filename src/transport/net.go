@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	UDP          = "udp"
+	TCP          = "tcp"
+	LCL_PORT     = "0"   // Dummy local port
+	MAX_DATAGRAM = 65507 // Largest UDP payload, datagrams are never truncated on receive
+	DIAL_TIMEOUT = time.Second * 1
+)
+
+/*
+ * NetTransport sends datagrams over UDP and streams over TCP. Outgoing datagrams are sent from
+ * localHost on a random port.
+ */
+type NetTransport struct {
+	localHost string
+}
+
+func NewNetTransport(localHost string) *NetTransport {
+	return &NetTransport{localHost}
+}
+
+func (t *NetTransport) SendTo(addr string, data []byte) error {
+	remoteAddr, err := net.ResolveUDPAddr(UDP, addr)
+	if err != nil {
+		return err
+	}
+	localAddr, err := net.ResolveUDPAddr(UDP, net.JoinHostPort(t.localHost, LCL_PORT))
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP(UDP, localAddr, remoteAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(data)
+	return err
+}
+
+func (t *NetTransport) ListenPacket(addr string) (PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr(UDP, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP(UDP, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpConn{conn, make([]byte, MAX_DATAGRAM)}, nil
+}
+
+func (t *NetTransport) Dial(addr string) (net.Conn, error) {
+	return net.DialTimeout(TCP, addr, DIAL_TIMEOUT)
+}
+
+func (t *NetTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen(TCP, addr)
+}
+
+type udpConn struct {
+	conn *net.UDPConn
+	buf  []byte
+}
+
+func (c *udpConn) ReadPacket() ([]byte, string, error) {
+	n, from, err := c.conn.ReadFromUDP(c.buf)
+	if err != nil {
+		return nil, "", err
+	}
+	data := make([]byte, n)
+	copy(data, c.buf[:n])
+	return data, from.String(), nil
+}
+
+func (c *udpConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,314 @@
+package transport
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const SIM_QUEUE_LEN = 1024 // Datagrams buffered per address before further ones are dropped
+
+var (
+	ErrPartitioned = errors.New("sim: link is down")
+	ErrRefused     = errors.New("sim: connection refused")
+	ErrClosed      = errors.New("sim: use of closed connection")
+)
+
+/*
+ * Link describes the behaviour of the network from one host to another
+ */
+type Link struct {
+	Latency   time.Duration // Delay of every datagram and of opening a stream
+	Jitter    time.Duration // Random extra delay up to Jitter, reorders datagrams
+	Loss      int           // Percentage 0-100 of datagrams dropped
+	Duplicate int           // Percentage 0-100 of datagrams delivered twice
+	Down      bool          // Partitioned, nothing is delivered and dials fail
+}
+
+/*
+ * Network is an in-process network for tests. Every node gets its own Transport from Node, datagrams
+ * and streams between two hosts follow the Link configured for that direction. Hosts are the host part
+ * of the addresses, so nodes meant to be partitioned from each other need distinct hosts.
+ */
+type Network struct {
+	mutex     sync.Mutex
+	defLink   Link
+	links     map[string]Link
+	endpoints map[string]*simPacketConn
+	listeners map[string]*simListener
+	dropped   int
+	rnd       *rand.Rand
+}
+
+func NewNetwork() *Network {
+	return &Network{
+		links:     make(map[string]Link),
+		endpoints: make(map[string]*simPacketConn),
+		listeners: make(map[string]*simListener),
+		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+/*
+ * Node returns the Transport of the node running on host
+ */
+func (n *Network) Node(host string) Transport {
+	return &simTransport{n, host}
+}
+
+/*
+ * SetDefaultLink sets the behaviour of every link without an explicit configuration
+ */
+func (n *Network) SetDefaultLink(l Link) {
+	n.mutex.Lock()
+	n.defLink = l
+	n.mutex.Unlock()
+}
+
+/*
+ * SetLink sets the behaviour of the link from one host to another
+ */
+func (n *Network) SetLink(from string, to string, l Link) {
+	n.mutex.Lock()
+	n.links[linkKey(from, to)] = l
+	n.mutex.Unlock()
+}
+
+/*
+ * Partition cuts every link between the hosts of a and the hosts of b in both directions
+ */
+func (n *Network) Partition(a []string, b []string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, x := range a {
+		for _, y := range b {
+			for _, key := range []string{linkKey(x, y), linkKey(y, x)} {
+				l, ok := n.links[key]
+				if !ok {
+					l = n.defLink
+				}
+				l.Down = true
+				n.links[key] = l
+			}
+		}
+	}
+}
+
+/*
+ * Heal brings every link back up, keeping their other settings
+ */
+func (n *Network) Heal() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for key, l := range n.links {
+		l.Down = false
+		n.links[key] = l
+	}
+	n.defLink.Down = false
+}
+
+/*
+ * Dropped returns the number of datagrams lost so far
+ */
+func (n *Network) Dropped() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.dropped
+}
+
+func (n *Network) link(from string, to string) Link {
+	if l, ok := n.links[linkKey(from, to)]; ok {
+		return l
+	}
+	return n.defLink
+}
+
+/*
+ * Roll the dice for loss, duplication and delay of one datagram. Must be called with mutex held
+ */
+func (n *Network) copies(l Link) []time.Duration {
+	if l.Down || n.rnd.Intn(100) < l.Loss {
+		n.dropped++
+		return nil
+	}
+	count := 1
+	if n.rnd.Intn(100) < l.Duplicate {
+		count = 2
+	}
+	delays := make([]time.Duration, count)
+	for i := range delays {
+		delays[i] = l.Latency
+		if l.Jitter > 0 {
+			delays[i] += time.Duration(n.rnd.Int63n(int64(l.Jitter)))
+		}
+	}
+	return delays
+}
+
+func (n *Network) deliver(addr string, data []byte, from string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	ep, ok := n.endpoints[addr]
+	if !ok {
+		return
+	}
+	select {
+	case ep.ch <- simPacket{data, from}:
+	default:
+		n.dropped++
+	}
+}
+
+type simTransport struct {
+	network *Network
+	host    string
+}
+
+func (t *simTransport) SendTo(addr string, data []byte) error {
+	n := t.network
+	n.mutex.Lock()
+	delays := n.copies(n.link(t.host, hostOf(addr)))
+	n.mutex.Unlock()
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	from := net.JoinHostPort(t.host, LCL_PORT)
+	for _, d := range delays {
+		if d == 0 {
+			n.deliver(addr, buf, from)
+		} else {
+			time.AfterFunc(d, func() { n.deliver(addr, buf, from) })
+		}
+	}
+	return nil
+}
+
+func (t *simTransport) ListenPacket(addr string) (PacketConn, error) {
+	n := t.network
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, ok := n.endpoints[addr]; ok {
+		return nil, errors.New("sim: address already in use " + addr)
+	}
+	ep := &simPacketConn{n, addr, make(chan simPacket, SIM_QUEUE_LEN)}
+	n.endpoints[addr] = ep
+	return ep, nil
+}
+
+func (t *simTransport) Dial(addr string) (net.Conn, error) {
+	n := t.network
+	n.mutex.Lock()
+	l := n.link(t.host, hostOf(addr))
+	ln, ok := n.listeners[addr]
+	n.mutex.Unlock()
+
+	if l.Down {
+		return nil, ErrPartitioned
+	}
+	if !ok {
+		return nil, ErrRefused
+	}
+	time.Sleep(l.Latency)
+
+	client, server := net.Pipe()
+	select {
+	case ln.conns <- server:
+		return client, nil
+	case <-ln.done:
+		return nil, ErrRefused
+	}
+}
+
+func (t *simTransport) Listen(addr string) (net.Listener, error) {
+	n := t.network
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if _, ok := n.listeners[addr]; ok {
+		return nil, errors.New("sim: address already in use " + addr)
+	}
+	ln := &simListener{n, addr, make(chan net.Conn), make(chan struct{}), sync.Once{}}
+	n.listeners[addr] = ln
+	return ln, nil
+}
+
+type simPacket struct {
+	data []byte
+	from string
+}
+
+type simPacketConn struct {
+	network *Network
+	addr    string
+	ch      chan simPacket
+}
+
+func (c *simPacketConn) ReadPacket() ([]byte, string, error) {
+	p, ok := <-c.ch
+	if !ok {
+		return nil, "", ErrClosed
+	}
+	return p.data, p.from, nil
+}
+
+func (c *simPacketConn) Close() error {
+	n := c.network
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.endpoints[c.addr] != c {
+		return ErrClosed
+	}
+	delete(n.endpoints, c.addr)
+	close(c.ch)
+	return nil
+}
+
+type simListener struct {
+	network *Network
+	addr    string
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (l *simListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, ErrClosed
+	}
+}
+
+func (l *simListener) Close() error {
+	l.once.Do(func() {
+		n := l.network
+		n.mutex.Lock()
+		delete(n.listeners, l.addr)
+		n.mutex.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *simListener) Addr() net.Addr {
+	return simAddr(l.addr)
+}
+
+type simAddr string
+
+func (a simAddr) Network() string { return "sim" }
+func (a simAddr) String() string  { return string(a) }
+
+func linkKey(from string, to string) string {
+	return from + ">" + to
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
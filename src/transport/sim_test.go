@@ -0,0 +1,187 @@
+package transport
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+const TEST_TIMEOUT = time.Second
+
+/*
+ * Read the next datagram of conn, failing the test if none arrives within TEST_TIMEOUT
+ */
+func readPacket(t *testing.T, conn PacketConn) ([]byte, string) {
+	t.Helper()
+	type packet struct {
+		data []byte
+		from string
+	}
+	ch := make(chan packet, 1)
+	go func() {
+		data, from, err := conn.ReadPacket()
+		if err == nil {
+			ch <- packet{data, from}
+		}
+	}()
+	select {
+	case p := <-ch:
+		return p.data, p.from
+	case <-time.After(TEST_TIMEOUT):
+		t.Fatal("no datagram received")
+	}
+	return nil, ""
+}
+
+func TestSendTo(t *testing.T) {
+	network := NewNetwork()
+	conn, err := network.Node("10.0.0.2").ListenPacket("10.0.0.2:6000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := network.Node("10.0.0.2").ListenPacket("10.0.0.2:6000"); err == nil {
+		t.Error("second listener on the same address was accepted")
+	}
+
+	data := []byte("syn")
+	if err := network.Node("10.0.0.1").SendTo("10.0.0.2:6000", data); err != nil {
+		t.Fatal(err)
+	}
+	// The datagram is copied, so the sender may reuse its buffer
+	data[0] = 'x'
+	got, from := readPacket(t, conn)
+	if string(got) != "syn" || from != "10.0.0.1:"+LCL_PORT {
+		t.Errorf("received %q from %s, want \"syn\" from 10.0.0.1:%s", got, from, LCL_PORT)
+	}
+
+	// Nothing listens on the address, the datagram is lost without an error like over UDP
+	if err := network.Node("10.0.0.1").SendTo("10.0.0.3:6000", data); err != nil {
+		t.Errorf("send to an address nobody listens on: %v", err)
+	}
+}
+
+func TestLinkLatencyAndLoss(t *testing.T) {
+	network := NewNetwork()
+	conn, err := network.Node("10.0.0.2").ListenPacket("10.0.0.2:6000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sender := network.Node("10.0.0.1")
+
+	network.SetLink("10.0.0.1", "10.0.0.2", Link{Latency: 50 * time.Millisecond})
+	start := time.Now()
+	sender.SendTo("10.0.0.2:6000", []byte("late"))
+	readPacket(t, conn)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("datagram arrived after %v on a link with 50ms latency", elapsed)
+	}
+
+	network.SetLink("10.0.0.1", "10.0.0.2", Link{Loss: 100})
+	for i := 0; i < 10; i++ {
+		sender.SendTo("10.0.0.2:6000", []byte("lost"))
+	}
+	if dropped := network.Dropped(); dropped != 10 {
+		t.Errorf("%d datagrams dropped on a link losing all of them, want 10", dropped)
+	}
+
+	// The link is configured per direction, the default still applies to the way back
+	back, err := network.Node("10.0.0.1").ListenPacket("10.0.0.1:6000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer back.Close()
+	network.Node("10.0.0.2").SendTo("10.0.0.1:6000", []byte("back"))
+	readPacket(t, back)
+
+	network.SetLink("10.0.0.1", "10.0.0.2", Link{Duplicate: 100})
+	sender.SendTo("10.0.0.2:6000", []byte("twice"))
+	for i := 0; i < 2; i++ {
+		if got, _ := readPacket(t, conn); string(got) != "twice" {
+			t.Errorf("copy %d is %q, want \"twice\"", i, got)
+		}
+	}
+}
+
+func TestPartitionAndHeal(t *testing.T) {
+	network := NewNetwork()
+	listener, err := network.Node("10.0.0.2").Listen("10.0.0.2:6001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := network.Node("10.0.0.2").ListenPacket("10.0.0.2:6000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	network.Partition([]string{"10.0.0.1"}, []string{"10.0.0.2", "10.0.0.3"})
+	if _, err := network.Node("10.0.0.1").Dial("10.0.0.2:6001"); err != ErrPartitioned {
+		t.Errorf("dial across the partition: %v, want %v", err, ErrPartitioned)
+	}
+	network.Node("10.0.0.1").SendTo("10.0.0.2:6000", []byte("cut"))
+	if dropped := network.Dropped(); dropped != 1 {
+		t.Errorf("%d datagrams dropped across the partition, want 1", dropped)
+	}
+	// Hosts on the same side still reach each other
+	network.Node("10.0.0.3").SendTo("10.0.0.2:6000", []byte("same side"))
+	if got, _ := readPacket(t, conn); string(got) != "same side" {
+		t.Errorf("received %q, want \"same side\"", got)
+	}
+
+	network.Heal()
+	go func() {
+		if c, err := listener.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := network.Node("10.0.0.1").Dial("10.0.0.2:6001")
+	if err != nil {
+		t.Fatalf("dial after healing: %v", err)
+	}
+	c.Close()
+}
+
+func TestStreams(t *testing.T) {
+	network := NewNetwork()
+	node := network.Node("10.0.0.2")
+	if _, err := network.Node("10.0.0.1").Dial("10.0.0.2:6001"); err != ErrRefused {
+		t.Errorf("dial without a listener: %v, want %v", err, ErrRefused)
+	}
+	listener, err := node.Listen("10.0.0.2:6001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node.Listen("10.0.0.2:6001"); err == nil {
+		t.Error("second listener on the same address was accepted")
+	}
+
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, io.LimitReader(c, 4))
+	}()
+	c, err := network.Node("10.0.0.1").Dial("10.0.0.2:6001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("echo"))
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(c, reply); err != nil || string(reply) != "echo" {
+		t.Errorf("read %q, %v, want \"echo\"", reply, err)
+	}
+	c.Close()
+
+	listener.Close()
+	if _, err := listener.Accept(); err != ErrClosed {
+		t.Errorf("accept after close: %v, want %v", err, ErrClosed)
+	}
+	if _, err := network.Node("10.0.0.1").Dial("10.0.0.2:6001"); err != ErrRefused {
+		t.Errorf("dial after the listener closed: %v, want %v", err, ErrRefused)
+	}
+}
@@ -0,0 +1,29 @@
+package transport
+
+import (
+	"net"
+)
+
+/*
+ * Transport is everything a node needs from the network: datagrams for the membership protocol and
+ * streams for file data and other bulk transfers. Addresses are host:port strings.
+ */
+type Transport interface {
+	// SendTo sends a single datagram to addr. Delivery is not guaranteed
+	SendTo(addr string, data []byte) error
+	// ListenPacket receives the datagrams sent to addr
+	ListenPacket(addr string) (PacketConn, error)
+	// Dial opens a reliable stream to addr
+	Dial(addr string) (net.Conn, error)
+	// Listen accepts the streams opened to addr
+	Listen(addr string) (net.Listener, error)
+}
+
+/*
+ * PacketConn is the receiving end of datagrams sent to one address
+ */
+type PacketConn interface {
+	// ReadPacket blocks until the next datagram arrives and returns its payload and sender
+	ReadPacket() ([]byte, string, error)
+	Close() error
+}
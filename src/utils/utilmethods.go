@@ -1,35 +1,23 @@
 package utils
 
 import (
-	"bytes"
-	"encoding/gob"
-	"fmt"
-	"io/ioutil"
 	"net"
 	"os/exec"
 	"path/filepath"
-	"time"
 )
 
-const LOG_FILE_GREP = "src/logs/logfile.log"
-
 /*
- * Executes grep in unix shell
+ * Executes grep in unix shell against the given log file
  */
-func ExecGrep(cmdArgs []string, machineName string) string {
+func ExecGrep(cmdArgs []string, logPath string, machineName string) string {
 
-	absPath, _ := filepath.Abs(LOG_FILE_GREP)
+	absPath, _ := filepath.Abs(logPath)
 	cmdArgs = append(cmdArgs, absPath)
-	fmt.Println("Complete String: ", cmdArgs)
 
-	cmdOut, cmdErr := exec.Command("grep", cmdArgs...).CombinedOutput()
+	// Exit status 1 means no line matched, anything else comes with a message in the output
+	cmdOut, _ := exec.Command("grep", cmdArgs...).CombinedOutput()
 
 	results := ""
-	//check if there is any error in our grep
-	if cmdErr != nil && cmdErr.Error() != "exit status 1" {
-		fmt.Println("ERROR WHILE READING")
-		fmt.Println(cmdErr)
-	}
 
 	if len(cmdOut) > 0 {
 		results = "Results from host [" + machineName + "]------------------------------------ " + "\n" + string(cmdOut)
@@ -39,49 +27,6 @@ func ExecGrep(cmdArgs []string, machineName string) string {
 	return results
 }
 
-/*
- * Sends a message to a server, and returns the result into a channel
- */
-func SendToServer(ipAddrs []string, message []string) {
-
-	out := make(chan string)
-
-	for _, ip := range ipAddrs {
-		go func(v string) {
-			conn, err := net.DialTimeout("tcp", v, time.Duration(1)*time.Second)
-			if err != nil {
-				out <- err.Error()
-				return
-			}
-
-			defer conn.Close()
-			// convert string array to bytes
-			buf := &bytes.Buffer{}
-			gob.NewEncoder(buf).Encode(message[:])
-			messageBytes := buf.Bytes()
-			// write bytes to the socket
-			_, err = conn.Write(messageBytes)
-			if err != nil {
-				out <- err.Error()
-				return
-			}
-
-			result, err := ioutil.ReadAll(conn)
-			if err != nil {
-				out <- err.Error()
-				return
-			}
-
-			out <- string(result)
-		}(ip)
-	}
-
-	for _ = range ipAddrs {
-		fmt.Println(<-out)
-		fmt.Printf("END----------------------------------------------------------\n")
-	}
-}
-
 /*
  * Returns the non loopback local IP of the host
  */